@@ -0,0 +1,124 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Diff loads the configurations `a` and `b` in fresh values created by `into`
+// and returns a human readable diff of their fields, one field per line with
+// nested fields joined by dots, e.g.
+//
+//	--- prod
+//	+++ staging
+//	- Endpoint.URL: "https://api.example.com"
+//	+ Endpoint.URL: "https://staging.example.com"
+//
+// An empty string is returned when both configurations are equal.
+func (c *ConfigDir) Diff(a, b string, into func() interface{}) (string, error) {
+	fieldsA, err := c.fields(a, into())
+	if err != nil {
+		return "", err
+	}
+	fieldsB, err := c.fields(b, into())
+	if err != nil {
+		return "", err
+	}
+
+	keys := make([]string, 0, len(fieldsA)+len(fieldsB))
+	for key := range fieldsA {
+		keys = append(keys, key)
+	}
+	for key := range fieldsB {
+		if _, ok := fieldsA[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	buf := new(bytes.Buffer)
+	for _, key := range keys {
+		valueA, okA := fieldsA[key]
+		valueB, okB := fieldsB[key]
+		if okA && okB && valueA == valueB {
+			continue
+		}
+
+		if buf.Len() == 0 {
+			fmt.Fprintf(buf, "--- %s\n+++ %s\n", a, b)
+		}
+		if okA {
+			fmt.Fprintf(buf, "- %s: %s\n", key, valueA)
+		}
+		if okB {
+			fmt.Fprintf(buf, "+ %s: %s\n", key, valueB)
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// fields loads a configuration and flattens it into a map of field paths to
+// their JSON encoded value.
+func (c *ConfigDir) fields(name string, as interface{}) (map[string]string, error) {
+	if err := c.Get(name, as); err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(as)
+	if err != nil {
+		return nil, errConfigDir(OpGet, name, err)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, errConfigDir(OpGet, name, err)
+	}
+
+	fields := make(map[string]string)
+	var flatten func(prefix string, value interface{})
+	flatten = func(prefix string, value interface{}) {
+		if object, ok := value.(map[string]interface{}); ok && len(object) > 0 {
+			for key, value := range object {
+				if prefix != "" {
+					key = prefix + "." + key
+				}
+				flatten(key, value)
+			}
+			return
+		}
+
+		encoded, _ := json.Marshal(value)
+		fields[prefix] = string(encoded)
+	}
+	flatten("", decoded)
+
+	return fields, nil
+}
+
+type (
+	ConfigDiffCmd struct {
+		A string `arg:"" placeholder:"<name>"`
+		B string `arg:"" placeholder:"<name>"`
+	}
+)
+
+func (u *ConfigDiffCmd) BeforeResolve(c *ConfigDirCli) (err error) {
+	return c.load()
+}
+
+func (u *ConfigDiffCmd) Run(c *ConfigDirCli) error {
+	// The concrete configuration type is unknown, the loader decodes into a
+	// generic map instead.
+	into := func() interface{} { return &map[string]interface{}{} }
+	diff, err := c.configDir.Diff(u.A, u.B, into)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(diff)
+	return nil
+}
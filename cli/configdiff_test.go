@@ -0,0 +1,46 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package cli
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigDirDiff(t *testing.T) {
+	type endpoint struct {
+		URL string
+	}
+	type someConfig struct {
+		Name     string
+		Endpoint endpoint
+	}
+
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	configDir, err := NewConfigDir(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, configDir.Set("prod", &someConfig{Name: "client", Endpoint: endpoint{URL: "https://api"}}))
+	require.NoError(t, configDir.Set("staging", &someConfig{Name: "client", Endpoint: endpoint{URL: "https://staging"}}))
+
+	into := func() interface{} { return &someConfig{} }
+	diff, err := configDir.Diff("prod", "staging", into)
+	require.NoError(t, err)
+	assert.Equal(t, `--- prod
++++ staging
+- Endpoint.URL: "https://api"
++ Endpoint.URL: "https://staging"
+`, diff)
+
+	diff, err = configDir.Diff("prod", "prod", into)
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+
+	_, err = configDir.Diff("prod", "missing", into)
+	assert.Error(t, err)
+}
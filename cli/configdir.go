@@ -3,15 +3,24 @@
 package cli
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/adrg/xdg"
 	"github.com/alecthomas/kong"
 )
@@ -21,12 +30,19 @@ type (
 	// configuration is given a context name, e.g. `prod`, `staging`, `devel` and
 	// each stores a specific configuration.
 	ConfigDir struct {
-		path   string
-		loader ConfigLoader
+		path      string
+		ext       string
+		loader    ConfigLoader
+		readOnly  bool
+		createDir bool
+		merge     bool
+		validator func(interface{}) error
 	}
 
-	configInfo struct {
+	// ConfigInfo identifies a configuration, e.g. the one returned by Current.
+	ConfigInfo struct {
 		Name string
+		// Path of the configuration's file.
 		Path string
 	}
 
@@ -39,13 +55,19 @@ type (
 
 // NewConfigDir creates a ConfigDir at a given path.
 func NewConfigDir(path string, opts ...ConfigDirOption) (*ConfigDir, error) {
-	cfg := &ConfigDir{path: path, loader: JSONLoader}
+	cfg := &ConfigDir{path: path, ext: configExt, loader: JSONLoader}
 	for _, opt := range opts {
 		if err := opt.apply(cfg); err != nil {
 			return nil, err
 		}
 	}
 
+	if cfg.createDir && !cfg.readOnly {
+		if err := os.MkdirAll(cfg.path, 0700); err != nil {
+			return nil, fmt.Errorf("ConfigDir's '%s' creation error: %w", cfg.path, err)
+		}
+	}
+
 	stat, err := os.Stat(cfg.path)
 	if err != nil {
 		return nil, fmt.Errorf("ConfigDir's '%s' error: %w", cfg.path, err)
@@ -69,6 +91,30 @@ func WithConfigDirLoader(loader ConfigLoader) ConfigDirOption {
 	})
 }
 
+// WithConfigExtension sets the extension of the configuration files, `.conf`
+// by default, e.g. `.yaml` to match a YAML loader. Only the files with this
+// extension are considered configurations. The extension must start with a dot
+// and can't contain a path separator.
+func WithConfigExtension(ext string) ConfigDirOption {
+	return configDirOptionFn(func(opt *ConfigDir) error {
+		if len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext, `/\`) {
+			return fmt.Errorf("Invalid configuration extension: '%s'", ext)
+		}
+		opt.ext = ext
+		return nil
+	})
+}
+
+// WithCreateDir creates the directory, and its parents, with 0700 permissions
+// when missing instead of failing, consistently with WithXdgConfigPath. It is
+// ignored along WithReadOnly.
+func WithCreateDir() ConfigDirOption {
+	return configDirOptionFn(func(opt *ConfigDir) error {
+		opt.createDir = true
+		return nil
+	})
+}
+
 func WithXdgConfigPath(configPath string) ConfigDirOption {
 	return configDirOptionFn(func(opt *ConfigDir) error {
 		// xdg ensure that the parent directories are automatically created. Thus we
@@ -83,90 +129,367 @@ func WithXdgConfigPath(configPath string) ConfigDirOption {
 	})
 }
 
+// WithXdgConfigPathFor stores the configurations in the `vendor/app`
+// directory of the XDG configuration home, e.g. `~/.config/vendor/app`. The
+// directory is created if missing, see Path for the resolved path.
+func WithXdgConfigPathFor(vendor, app string) ConfigDirOption {
+	return configDirOptionFn(func(opt *ConfigDir) error {
+		for _, segment := range []string{vendor, app} {
+			if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, `/\`) {
+				return fmt.Errorf("Invalid configuration path segment: '%s'", segment)
+			}
+		}
+
+		return WithXdgConfigPath(path.Join(vendor, app)).apply(opt)
+	})
+}
+
+// ErrReadOnly is returned by the operations writing to a ConfigDir created
+// with WithReadOnly.
+var ErrReadOnly = errors.New("read-only configuration directory")
+
+// WithReadOnly makes the operations writing to the directory, i.e. Set, Use,
+// Rename and Copy, fail with ErrReadOnly without touching the filesystem,
+// e.g. when the directory is mounted read-only. Reading operations are
+// unaffected.
+func WithReadOnly(readOnly bool) ConfigDirOption {
+	return configDirOptionFn(func(opt *ConfigDir) error {
+		opt.readOnly = readOnly
+		return nil
+	})
+}
+
+// WithConfigValidator validates the configurations passed to Set before
+// writing them, e.g. to reject missing required fields. A configuration
+// failing validation is rejected with the validator's error and the existing
+// file is left untouched.
+func WithConfigValidator(validator func(interface{}) error) ConfigDirOption {
+	return configDirOptionFn(func(opt *ConfigDir) error {
+		opt.validator = validator
+		return nil
+	})
+}
+
+// WithMergeOnSet makes Set merge the configuration over the existing file
+// instead of replacing it, such that the keys unknown to the configuration's
+// type are preserved, e.g. written by a newer version of the CLI. Nested
+// objects are merged recursively. The keys known to the configuration's type
+// are replaced, or removed when the configuration omits them, e.g. a cleared
+// `omitempty` field.
+//
+// The known keys are those surviving a round trip of the existing file through
+// the configuration's type. The merge goes through the loader's generic
+// representation, i.e. map[string]interface{}, thus the keys may be reordered.
+// JSON numbers are preserved verbatim.
+func WithMergeOnSet() ConfigDirOption {
+	return configDirOptionFn(func(opt *ConfigDir) error {
+		opt.merge = true
+		return nil
+	})
+}
+
+// Path returns the resolved directory where configurations are stored.
+func (c *ConfigDir) Path() string {
+	return c.path
+}
+
 func (c *ConfigDir) Get(name string, as interface{}) error {
 	info, err := c.configInfo(name, true)
 	if err != nil {
-		return errConfigDir(name, fmt.Errorf("get info: %w", err))
+		return errConfigDir(OpGet, name, fmt.Errorf("get info: %w", err))
 	}
 	if err := c.load(info, as); err != nil {
-		return errConfigDir(name, fmt.Errorf("load: %w", err))
+		return errConfigDir(OpGet, name, fmt.Errorf("load: %w", err))
+	}
+	return nil
+}
+
+// Dump writes the configuration `name` to w verbatim, i.e. in the loader's
+// format, e.g. to pipe it to another program.
+func (c *ConfigDir) Dump(name string, w io.Writer) error {
+	info, err := c.configInfo(name, true)
+	if err != nil {
+		return errConfigDir(OpDump, name, fmt.Errorf("get info: %w", err))
+	}
+	f, err := os.Open(info.Path)
+	if err != nil {
+		return errConfigDir(OpDump, name, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return errConfigDir(OpDump, name, err)
+	}
+	return nil
+}
+
+// LoadFrom loads a configuration from r with the loader, e.g. from stdin,
+// without touching the directory. The name only identifies the configuration
+// in errors.
+func (c *ConfigDir) LoadFrom(name string, r io.Reader, as interface{}) error {
+	bytes, err := io.ReadAll(r)
+	if err != nil {
+		return errConfigDir(OpGet, name, fmt.Errorf("read: %w", err))
+	}
+	if err := c.loader.Unmarshal(bytes, as); err != nil {
+		return errConfigDir(OpGet, name, fmt.Errorf("load: %w", err))
 	}
 	return nil
 }
 
 func (c *ConfigDir) Set(name string, from interface{}) error {
+	if c.readOnly {
+		return errConfigDir(OpSet, name, ErrReadOnly)
+	}
+
 	info, err := c.configInfo(name, false)
 	if err != nil {
-		return errConfigDir(name, fmt.Errorf("get info: %w", err))
+		return errConfigDir(OpSet, name, fmt.Errorf("get info: %w", err))
+	}
+	if c.validator != nil {
+		if err := c.validator(from); err != nil {
+			return errConfigDir(OpSet, name, fmt.Errorf("validate: %w", err))
+		}
 	}
 	if err := c.dump(info, from); err != nil {
-		return errConfigDir(name, fmt.Errorf("dump: %w", err))
+		return errConfigDir(OpSet, name, fmt.Errorf("dump: %w", err))
 	}
 
 	return nil
 }
 
 func (c *ConfigDir) Use(name string) error {
+	if c.readOnly {
+		return errConfigDir(OpUse, name, ErrReadOnly)
+	}
+
 	_, err := c.configInfo(name, true)
 	if err != nil {
-		return errConfigDir(name, fmt.Errorf("get info: %w", err))
+		return errConfigDir(OpUse, name, fmt.Errorf("get info: %w", err))
 	}
 
-	linkPath := filepath.Join(c.path, currentName)
-	file, err := os.Create(linkPath)
+	if err := c.writeCurrent(name); err != nil {
+		return errConfigDir(OpUse, name, fmt.Errorf("write current: %w", err))
+	}
+	return nil
+}
+
+type (
+	// WriteOption alters the behavior of ConfigDir operations creating a
+	// configuration from another one, e.g. Rename or Copy.
+	WriteOption func(*writeOptions)
+
+	writeOptions struct {
+		overwrite bool
+	}
+)
+
+// WithOverwrite allows replacing an existing destination configuration.
+func WithOverwrite() WriteOption {
+	return func(opts *writeOptions) {
+		opts.overwrite = true
+	}
+}
+
+// Rename renames the configuration `oldName` to `newName`. If `oldName` is the
+// current configuration, the current pointer follows the renamed
+// configuration. Renaming onto an existing configuration fails unless
+// WithOverwrite is passed.
+func (c *ConfigDir) Rename(oldName, newName string, opts ...WriteOption) error {
+	if c.readOnly {
+		return errConfigDir(OpRename, oldName, ErrReadOnly)
+	}
+
+	var options writeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	src, err := c.configInfo(oldName, true)
+	if err != nil {
+		return errConfigDir(OpRename, oldName, fmt.Errorf("get info: %w", err))
+	}
+	dst, err := c.configInfo(newName, false)
 	if err != nil {
-		return errConfigDir(name, fmt.Errorf("link current: %w", err))
+		return errConfigDir(OpRename, newName, fmt.Errorf("get info: %w", err))
+	}
+
+	if !options.overwrite {
+		if _, err := os.Stat(dst.Path); err == nil {
+			return errConfigDir(OpRename, newName, os.ErrExist)
+		}
 	}
 
-	if _, err := file.Write([]byte(name)); err != nil {
-		return errConfigDir(name, fmt.Errorf("write current: %w", err))
+	if err := os.Rename(src.Path, dst.Path); err != nil {
+		return errConfigDir(OpRename, oldName, err)
 	}
+
+	if current, err := c.CurrentName(); err == nil && current == oldName {
+		if err := c.writeCurrent(newName); err != nil {
+			return errConfigDir(OpRename, newName, fmt.Errorf("write current: %w", err))
+		}
+	}
+
+	return nil
+}
+
+// Copy duplicates the configuration `src` as `dst`, e.g. to create a staging
+// configuration from the production one. The file is copied verbatim, thus the
+// loader's formatting is preserved. The current configuration is unchanged.
+// Copying onto an existing configuration fails unless WithOverwrite is passed.
+func (c *ConfigDir) Copy(src, dst string, opts ...WriteOption) error {
+	if c.readOnly {
+		return errConfigDir(OpCopy, dst, ErrReadOnly)
+	}
+
+	var options writeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	srcInfo, err := c.configInfo(src, true)
+	if err != nil {
+		return errConfigDir(OpCopy, src, fmt.Errorf("get info: %w", err))
+	}
+	dstInfo, err := c.configInfo(dst, false)
+	if err != nil {
+		return errConfigDir(OpCopy, dst, fmt.Errorf("get info: %w", err))
+	}
+
+	if !options.overwrite {
+		if _, err := os.Stat(dstInfo.Path); err == nil {
+			return errConfigDir(OpCopy, dst, os.ErrExist)
+		}
+	}
+
+	content, err := os.ReadFile(srcInfo.Path)
+	if err != nil {
+		return errConfigDir(OpCopy, src, err)
+	}
+	if err := os.WriteFile(dstInfo.Path, content, 0666); err != nil {
+		return errConfigDir(OpCopy, dst, err)
+	}
+
 	return nil
 }
 
 func (c *ConfigDir) List() ([]string, error) {
-	entries, err := os.ReadDir(c.path)
+	entries, err := c.configEntries()
 	if err != nil {
 		return nil, err
 	}
 
 	list := make([]string, 0, len(entries))
 	for _, entry := range entries {
-		if filepath.Ext(entry.Name()) != configExt || !entry.Type().IsRegular() {
+		list = append(list, c.configName(entry.Name()))
+	}
+
+	return list, nil
+}
+
+// configEntries returns the directory entries of the configurations.
+func (c *ConfigDir) configEntries() ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(c.path)
+	if err != nil {
+		return nil, errConfigDir(OpList, "", err)
+	}
+
+	configs := make([]os.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != c.ext || !entry.Type().IsRegular() {
 			continue
 		}
 
-		list = append(list, configName(entry.Name()))
+		configs = append(configs, entry)
 	}
 
-	return list, nil
+	return configs, nil
 }
 
-func (c *ConfigDir) Current(as interface{}) (*configInfo, error) {
-	linkPath := filepath.Join(c.path, currentName)
-	linkStat, err := os.Stat(linkPath)
+// ConfigEntry describes a configuration, see ListInfo.
+type ConfigEntry struct {
+	Name    string
+	Path    string
+	ModTime time.Time
+	// IsCurrent is true for the configuration pointed by the current
+	// configuration pointer, see Use.
+	IsCurrent bool
+}
+
+// ListInfo behaves like List but returns the metadata of each configuration.
+func (c *ConfigDir) ListInfo() ([]ConfigEntry, error) {
+	dirEntries, err := c.configEntries()
 	if err != nil {
 		return nil, err
 	}
 
+	// A missing current configuration is not an error for listing.
+	current, _ := c.CurrentName()
+
+	entries := make([]ConfigEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		name := c.configName(dirEntry.Name())
+		stat, err := dirEntry.Info()
+		if os.IsNotExist(err) {
+			// Removed since listed.
+			continue
+		} else if err != nil {
+			return nil, errConfigDir(OpList, name, err)
+		}
+
+		entries = append(entries, ConfigEntry{
+			Name:      name,
+			Path:      filepath.Join(c.path, dirEntry.Name()),
+			ModTime:   stat.ModTime(),
+			IsCurrent: name == current,
+		})
+	}
+
+	return entries, nil
+}
+
+// ErrNoCurrentConfig is returned, wrapped, by CurrentName and Current when no
+// configuration was selected with Use.
+var ErrNoCurrentConfig = errors.New("no current config, see 'config use'")
+
+// CurrentName returns the name of the current configuration, i.e. the last
+// name passed to Use, without loading it.
+func (c *ConfigDir) CurrentName() (string, error) {
+	linkPath := filepath.Join(c.path, currentName)
+	linkStat, err := os.Stat(linkPath)
+	if os.IsNotExist(err) {
+		return "", errConfigDir(OpCurrent, currentName, ErrNoCurrentConfig)
+	} else if err != nil {
+		return "", errConfigDir(OpCurrent, currentName, err)
+	}
+
 	if !linkStat.Mode().IsRegular() {
-		return nil, errConfigDir(currentName, errors.New("not a regular file"))
+		return "", errConfigDir(OpCurrent, currentName, errors.New("not a regular file"))
 	}
 
 	linkContent, err := os.ReadFile(linkPath)
 	if err != nil {
-		return nil, errConfigDir(currentName, err)
+		return "", errConfigDir(OpCurrent, currentName, err)
 	}
 
-	name := string(linkContent)
+	return string(linkContent), nil
+}
+
+// Current loads the current configuration into `as` and returns its
+// ConfigInfo, e.g. to report the path of the active configuration.
+func (c *ConfigDir) Current(as interface{}) (*ConfigInfo, error) {
+	name, err := c.CurrentName()
+	if err != nil {
+		return nil, err
+	}
 
 	info, err := c.configInfo(name, true)
 	if err != nil {
-		return nil, errConfigDir(name, err)
+		return nil, errConfigDir(OpCurrent, name, err)
 	}
 
 	if err := c.load(info, as); err != nil {
-		return nil, errConfigDir(name, err)
+		return nil, errConfigDir(OpCurrent, name, err)
 	}
 
 	return info, nil
@@ -177,6 +500,10 @@ type (
 		Config string `opt:""`
 	}
 
+	ConfigShowCmd struct {
+		Name string `arg:"" optional:"" placeholder:"<name>" help:"Configuration to show, the current one by default."`
+	}
+
 	ConfigUseCmd struct {
 		Name string `arg:"" placeholder:"<name>"`
 	}
@@ -184,9 +511,23 @@ type (
 	ConfigListCmd struct {
 	}
 
+	ConfigCurrentCmd struct {
+	}
+
+	ConfigCopyCmd struct {
+		Src       string `arg:"" placeholder:"<src>"`
+		Dst       string `arg:"" placeholder:"<dst>"`
+		Overwrite bool   `opt:"" help:"Replace <dst> if it exists."`
+	}
+
 	ConfigDirCmd struct {
-		Use  ConfigUseCmd  `cmd:"use"`
-		List ConfigListCmd `cmd:"list"`
+		Use     ConfigUseCmd     `cmd:"use"`
+		List    ConfigListCmd    `cmd:"list"`
+		Current ConfigCurrentCmd `cmd:"current"`
+		Diff    ConfigDiffCmd    `cmd:"diff"`
+		Copy    ConfigCopyCmd    `cmd:"copy"`
+		Doctor  ConfigDoctorCmd  `cmd:"doctor"`
+		Show    ConfigShowCmd    `cmd:"show"`
 	}
 
 	ConfigDirCli struct {
@@ -196,33 +537,73 @@ type (
 		path      string
 		options   []ConfigDirOption
 		configDir *ConfigDir
+
+		// Standard streams of the "-" configuration, os.Stdin and os.Stdout
+		// if nil.
+		stdin  io.Reader
+		stdout io.Writer
 	}
 )
 
+// StdioConfig is the configuration name designating the standard streams, see
+// ConfigDirCli.Get and ConfigDirCli.Set.
+const StdioConfig = "-"
+
 func (c *ConfigDirCli) KongInit(path string, options ...ConfigDirOption) kong.Option {
 	c.path = path
 	c.options = options
 	return kong.Bind(c)
 }
 
+// ConfigEnvVar is the environment variable consulted by ConfigDirCli.Get to
+// select the configuration when the `--config` flag is not provided.
+const ConfigEnvVar = "OPTABLE_CONFIG"
+
+// Get loads the configuration selected by, in order of precedence, the
+// `--config` flag, the ConfigEnvVar environment variable or the current
+// configuration. The StdioConfig name, i.e. `--config -`, loads the
+// configuration from stdin, see ConfigDir.LoadFrom.
 func (c *ConfigDirCli) Get(cfg interface{}) error {
 	configDir := c.configDir
 	target := c.ConfigDirFlag.Config
+	if target == "" {
+		target = os.Getenv(ConfigEnvVar)
+	}
+
+	if target == StdioConfig {
+		return configDir.LoadFrom(target, c.stdinReader(), cfg)
+	}
 
 	if target == "" {
 		_, err := configDir.Current(cfg)
+		if errors.Is(err, ErrNoCurrentConfig) {
+			return fmt.Errorf("No configuration selected, pass --config: %w", err)
+		}
 		return err
 	}
 
 	return configDir.Get(target, cfg)
 }
 
+// Set stores the configuration under the name of the `--config` flag,
+// `default` if not provided, and optionally makes it the current one. The
+// StdioConfig name writes the configuration to stdout in the loader's format
+// instead.
 func (c *ConfigDirCli) Set(cfg interface{}, setCurrent bool) error {
 	target := c.ConfigDirFlag.Config
 	if target == "" {
 		target = "default"
 	}
 
+	if target == StdioConfig {
+		bytes, err := c.configDir.loader.Marshal(cfg)
+		if err != nil {
+			return errConfigDir(OpSet, target, fmt.Errorf("dump: %w", err))
+		}
+		_, err = c.stdoutWriter().Write(bytes)
+		return errConfigDir(OpSet, target, err)
+	}
+
 	if err := c.configDir.Set(target, cfg); err != nil {
 		return err
 	}
@@ -234,6 +615,20 @@ func (c *ConfigDirCli) Set(cfg interface{}, setCurrent bool) error {
 	return nil
 }
 
+func (c *ConfigDirCli) stdinReader() io.Reader {
+	if c.stdin == nil {
+		return os.Stdin
+	}
+	return c.stdin
+}
+
+func (c *ConfigDirCli) stdoutWriter() io.Writer {
+	if c.stdout == nil {
+		return os.Stdout
+	}
+	return c.stdout
+}
+
 func (c *ConfigDirCli) load() (err error) {
 	if c.configDir != nil {
 		return nil
@@ -267,16 +662,27 @@ func (u *ConfigListCmd) BeforeResolve(c *ConfigDirCli) (err error) {
 }
 
 func (u *ConfigListCmd) Run(c *ConfigDirCli) error {
-	configs, err := c.configDir.List()
+	entries, err := c.configDir.ListInfo()
 	if err != nil {
 		return fmt.Errorf("Failed listing configs: %w", err)
 	}
 
-	for _, name := range configs {
-		fmt.Println(name)
-	}
+	return writeConfigList(os.Stdout, entries)
+}
 
-	return nil
+// writeConfigList renders the configurations as an aligned table, the current
+// configuration is marked with a `*`.
+func writeConfigList(w io.Writer, entries []ConfigEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CURRENT\tNAME\tMODIFIED")
+	for _, entry := range entries {
+		current := ""
+		if entry.IsCurrent {
+			current = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", current, entry.Name, entry.ModTime.Format(time.RFC3339))
+	}
+	return tw.Flush()
 }
 
 func (u *ConfigUseCmd) BeforeResolve(c *ConfigDirCli) (err error) {
@@ -287,19 +693,62 @@ func (u *ConfigUseCmd) Run(c *ConfigDirCli) error {
 	return c.configDir.Use(u.Name)
 }
 
-// We might want to make that configurable, the idea of having a known suffix is to allow
-// other programs to write files in the config dir without being picked up by the facility.
-// There might be better ways of doing that.
+func (u *ConfigCopyCmd) BeforeResolve(c *ConfigDirCli) (err error) {
+	return c.load()
+}
+
+func (u *ConfigCopyCmd) Run(c *ConfigDirCli) error {
+	var opts []WriteOption
+	if u.Overwrite {
+		opts = append(opts, WithOverwrite())
+	}
+	return c.configDir.Copy(u.Src, u.Dst, opts...)
+}
+
+func (u *ConfigShowCmd) BeforeResolve(c *ConfigDirCli) (err error) {
+	return c.load()
+}
+
+func (u *ConfigShowCmd) Run(c *ConfigDirCli) error {
+	name := u.Name
+	if name == "" {
+		current, err := c.configDir.CurrentName()
+		if err != nil {
+			return err
+		}
+		name = current
+	}
+
+	return c.configDir.Dump(name, c.stdoutWriter())
+}
+
+func (u *ConfigCurrentCmd) BeforeResolve(c *ConfigDirCli) (err error) {
+	return c.load()
+}
+
+func (u *ConfigCurrentCmd) Run(c *ConfigDirCli) error {
+	name, err := c.configDir.CurrentName()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(name)
+	return nil
+}
+
+// Default extension of the configuration files, see WithConfigExtension. The
+// idea of having a known suffix is to allow other programs to write files in
+// the config dir without being picked up by the facility.
 const configExt = ".conf"
 
 // File containing the pointer to current config
 const currentName = ".current"
 
-func configName(path string) string {
-	return filepath.Base(strings.TrimSuffix(path, configExt))
+func (c *ConfigDir) configName(path string) string {
+	return filepath.Base(strings.TrimSuffix(path, c.ext))
 }
 
-func (c *ConfigDir) load(info *configInfo, as interface{}) error {
+func (c *ConfigDir) load(info *ConfigInfo, as interface{}) error {
 	bytes, err := os.ReadFile(info.Path)
 	if err != nil {
 		return err
@@ -308,27 +757,103 @@ func (c *ConfigDir) load(info *configInfo, as interface{}) error {
 	return c.loader.Unmarshal(bytes, as)
 }
 
-func (c *ConfigDir) dump(info *configInfo, from interface{}) error {
+func (c *ConfigDir) writeCurrent(name string) error {
+	return os.WriteFile(filepath.Join(c.path, currentName), []byte(name), 0666)
+}
+
+func (c *ConfigDir) dump(info *ConfigInfo, from interface{}) error {
 	bytes, err := c.loader.Marshal(from)
 	if err != nil {
 		return err
 	}
 
+	if c.merge {
+		if bytes, err = c.mergeExisting(info, from, bytes); err != nil {
+			return fmt.Errorf("merge: %w", err)
+		}
+	}
+
 	return os.WriteFile(info.Path, bytes, 0666)
 }
 
+// mergeExisting merges the marshaled configuration `from` over the existing
+// file, if any, see WithMergeOnSet.
+func (c *ConfigDir) mergeExisting(info *ConfigInfo, from interface{}, marshaled []byte) ([]byte, error) {
+	raw, err := os.ReadFile(info.Path)
+	if os.IsNotExist(err) {
+		return marshaled, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	existing, err := unmarshalConfigMap(c.loader, raw)
+	if err != nil {
+		return nil, err
+	}
+	update, err := unmarshalConfigMap(c.loader, marshaled)
+	if err != nil {
+		return nil, err
+	}
+
+	// The keys of the existing file known to the configuration's type survive
+	// a round trip through it, the unknown ones are dropped.
+	typ := reflect.TypeOf(from)
+	if typ == nil {
+		return marshaled, nil
+	} else if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	roundTrip := reflect.New(typ).Interface()
+	if err := c.loader.Unmarshal(raw, roundTrip); err != nil {
+		return nil, err
+	}
+	roundTripped, err := c.loader.Marshal(roundTrip)
+	if err != nil {
+		return nil, err
+	}
+	known, err := unmarshalConfigMap(c.loader, roundTripped)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.loader.Marshal(mergeConfigMaps(existing, known, update))
+}
+
+// mergeConfigMaps returns the keys of existing not found in known, overridden
+// by update. The objects found in both existing and update are merged
+// recursively.
+func mergeConfigMaps(existing, known, update map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(existing)+len(update))
+	for key, value := range existing {
+		if _, ok := known[key]; !ok {
+			merged[key] = value
+		}
+	}
+
+	for key, value := range update {
+		existingMap, existingOk := existing[key].(map[string]interface{})
+		updateMap, updateOk := value.(map[string]interface{})
+		if existingOk && updateOk {
+			knownMap, _ := known[key].(map[string]interface{})
+			value = mergeConfigMaps(existingMap, knownMap, updateMap)
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
 // Starts with an alphanum and at least 2 characters to avoid "-" config names
 // which can be dangerous to work with when interacting with shells.
-const allowedConfigNamePattern = "[a-zA-Z0-9][a-zA-Z0-9-_]+"
+const allowedConfigNamePattern = "^[a-zA-Z0-9][a-zA-Z0-9-_]+$"
 
 var allowedConfigNameRegexp = regexp.MustCompile(allowedConfigNamePattern)
 
-func (c *ConfigDir) configInfo(name string, mustExist bool) (*configInfo, error) {
+func (c *ConfigDir) configInfo(name string, mustExist bool) (*ConfigInfo, error) {
 	if !allowedConfigNameRegexp.MatchString(name) {
 		return nil, fmt.Errorf("Context name must match: %s", allowedConfigNamePattern)
 	}
 
-	path := filepath.Join(c.path, name) + configExt
+	path := filepath.Join(c.path, name) + c.ext
 	if mustExist {
 		stat, err := os.Stat(path)
 		if err != nil {
@@ -339,14 +864,49 @@ func (c *ConfigDir) configInfo(name string, mustExist bool) (*configInfo, error)
 		}
 	}
 
-	return &configInfo{Path: path, Name: name}, nil
+	return &ConfigInfo{Path: path, Name: name}, nil
 }
 
-func errConfigDir(name string, err error) error {
+// Operations reported by ConfigDirError.
+const (
+	OpGet     = "get"
+	OpSet     = "set"
+	OpUse     = "use"
+	OpList    = "list"
+	OpCurrent = "current"
+	OpRename  = "rename"
+	OpCopy    = "copy"
+	OpDump    = "dump"
+)
+
+// ConfigDirError is returned by ConfigDir operations. It records the
+// operation and the configuration name which failed along the underlying
+// error. Use errors.As to extract it.
+type ConfigDirError struct {
+	// Name of the configuration, empty when the operation doesn't target a
+	// specific configuration, e.g. List.
+	Name string
+	// Op is the failing operation, see the Op* constants.
+	Op  string
+	Err error
+}
+
+func (e *ConfigDirError) Error() string {
+	if e.Name == "" {
+		return fmt.Sprintf("configdir: %s", e.Err.Error())
+	}
+	return fmt.Sprintf("configdir: %s: %s", e.Name, e.Err.Error())
+}
+
+func (e *ConfigDirError) Unwrap() error {
+	return e.Err
+}
+
+func errConfigDir(op, name string, err error) error {
 	if err == nil {
 		return nil
 	}
-	return fmt.Errorf("configdir: %s: %w", name, err)
+	return &ConfigDirError{Name: name, Op: op, Err: err}
 }
 
 type ConfigLoader interface {
@@ -354,6 +914,26 @@ type ConfigLoader interface {
 	Marshal(interface{}) ([]byte, error)
 }
 
+// configMapUnmarshaler is implemented by the loaders requiring a specific
+// decoding into a generic map to be lossless, see unmarshalConfigMap.
+type configMapUnmarshaler interface {
+	unmarshalMap([]byte) (map[string]interface{}, error)
+}
+
+// unmarshalConfigMap decodes a configuration into a generic map with the
+// loader such that marshaling it back preserves the values.
+func unmarshalConfigMap(loader ConfigLoader, b []byte) (map[string]interface{}, error) {
+	if l, ok := loader.(configMapUnmarshaler); ok {
+		return l.unmarshalMap(b)
+	}
+
+	m := map[string]interface{}{}
+	if err := loader.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Simple implementation of a loader marshaling from/into a json structure
 type jsonLoader struct{}
 
@@ -366,3 +946,127 @@ func (l *jsonLoader) Unmarshal(b []byte, to interface{}) error {
 func (l *jsonLoader) Marshal(from interface{}) ([]byte, error) {
 	return json.Marshal(from)
 }
+
+// unmarshalMap decodes numbers as json.Number, float64 would round the
+// integers above 2^53.
+func (l *jsonLoader) unmarshalMap(b []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	m := map[string]interface{}{}
+	if err := decoder.Decode(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Implementation of a loader marshaling into a json structure indented with
+// two spaces, easier to hand edit and to review under version control than
+// the compact output of JSONLoader. Unmarshaling is identical to JSONLoader.
+type jsonIndentLoader struct {
+	jsonLoader
+}
+
+var JSONIndentLoader = &jsonIndentLoader{}
+
+func (l *jsonIndentLoader) Marshal(from interface{}) ([]byte, error) {
+	return json.MarshalIndent(from, "", "  ")
+}
+
+// Simple implementation of a loader marshaling from/into a toml structure
+type tomlLoader struct{}
+
+var TOMLLoader = &tomlLoader{}
+
+func (l *tomlLoader) Unmarshal(b []byte, to interface{}) error {
+	return toml.Unmarshal(b, to)
+}
+
+func (l *tomlLoader) Marshal(from interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := toml.NewEncoder(buf).Encode(from); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ErrConfigDecryption is returned by the loader of NewEncryptedLoader when a
+// configuration can't be decrypted, usually because the key is wrong.
+var ErrConfigDecryption = errors.New("config decryption failed")
+
+// Decorates a loader with AES-GCM encryption. The nonce is prefixed to the
+// ciphertext.
+type encryptedLoader struct {
+	inner ConfigLoader
+	key   []byte
+}
+
+// NewEncryptedLoader wraps a ConfigLoader such that configurations are
+// encrypted at rest with AES-GCM. The key must be 16, 24 or 32 bytes long to
+// select AES-128, AES-192 or AES-256.
+func NewEncryptedLoader(inner ConfigLoader, key []byte) ConfigLoader {
+	return &encryptedLoader{inner: inner, key: key}
+}
+
+func (l *encryptedLoader) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(l.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (l *encryptedLoader) decrypt(b []byte) ([]byte, error) {
+	aead, err := l.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(b) < nonceSize {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrConfigDecryption)
+	}
+
+	plaintext, err := aead.Open(nil, b[:nonceSize], b[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrConfigDecryption, err)
+	}
+	return plaintext, nil
+}
+
+func (l *encryptedLoader) Unmarshal(b []byte, to interface{}) error {
+	plaintext, err := l.decrypt(b)
+	if err != nil {
+		return err
+	}
+
+	return l.inner.Unmarshal(plaintext, to)
+}
+
+func (l *encryptedLoader) unmarshalMap(b []byte) (map[string]interface{}, error) {
+	plaintext, err := l.decrypt(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalConfigMap(l.inner, plaintext)
+}
+
+func (l *encryptedLoader) Marshal(from interface{}) ([]byte, error) {
+	aead, err := l.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := l.inner.Marshal(from)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
@@ -3,11 +3,18 @@
 package cli
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/adrg/xdg"
 	"github.com/alecthomas/kong"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -49,6 +56,29 @@ func TestConfigDirCurrentFailsOnAbsentLink(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestConfigDirWithCreateDir(t *testing.T) {
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nested", "configs")
+
+	_, err := NewConfigDir(path)
+	assert.Error(t, err)
+	_, err = NewConfigDir(path, WithCreateDir(), WithReadOnly(true))
+	assert.Error(t, err, "a read-only directory is not created")
+
+	configDir, err := NewConfigDir(path, WithCreateDir())
+	require.NoError(t, err)
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.True(t, stat.IsDir())
+	assert.Equal(t, os.FileMode(0700), stat.Mode().Perm())
+	assert.NoError(t, configDir.Set("prod", map[string]string{"Name": "prod"}))
+
+	// An existing directory is kept as is.
+	_, err = NewConfigDir(path, WithCreateDir())
+	assert.NoError(t, err)
+}
+
 func TestConfigDirOnlyListRecognizedFiles(t *testing.T) {
 	dir := requireTempDir(t)
 	defer os.RemoveAll(dir)
@@ -65,6 +95,34 @@ func TestConfigDirOnlyListRecognizedFiles(t *testing.T) {
 	assert.True(t, strings.HasPrefix(list[0], "yes-"))
 }
 
+func TestConfigDirWithConfigExtension(t *testing.T) {
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, ext := range []string{"", ".", "yaml", "./yaml"} {
+		_, err := NewConfigDir(dir, WithConfigExtension(ext))
+		assert.Error(t, err, ext)
+	}
+
+	configDir, err := NewConfigDir(dir, WithConfigExtension(".json"))
+	require.NoError(t, err)
+	_, err = os.CreateTemp(dir, "nope-*"+configExt)
+	require.NoError(t, err)
+
+	require.NoError(t, configDir.Set("prod", map[string]string{"Name": "prod"}))
+	assert.FileExists(t, filepath.Join(dir, "prod.json"))
+
+	list, err := configDir.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod"}, list)
+
+	require.NoError(t, configDir.Use("prod"))
+	var config map[string]string
+	_, err = configDir.Current(&config)
+	require.NoError(t, err)
+	assert.Equal(t, "prod", config["Name"])
+}
+
 func TestConfigDirValidatesName(t *testing.T) {
 	type someConfig struct{}
 
@@ -81,6 +139,9 @@ func TestConfigDirValidatesName(t *testing.T) {
 		"-",
 		".",
 		"..",
+		"../escaped",
+		"nested/name",
+		"valid-name/../../escaped",
 	}
 	conf := &someConfig{}
 	for _, invalid := range invalids {
@@ -92,29 +153,39 @@ func TestConfigDirValidatesName(t *testing.T) {
 	assert.NoError(t, err)
 }
 
-func TestConfigDirSetDumpsAndLoadConfig(t *testing.T) {
+func testConfigDirSetDumpsAndLoadConfig(t *testing.T, opts ...ConfigDirOption) {
+	type nestedConfig struct {
+		URL string
+	}
 	type someConfig struct {
-		Name  string
-		Count int
-		Odd   bool
+		Name   string
+		Count  int
+		Odd    bool
+		Nested nestedConfig
 	}
 
 	dir := requireTempDir(t)
 	defer os.RemoveAll(dir)
 
-	configDir, err := NewConfigDir(dir)
+	configDir, err := NewConfigDir(dir, opts...)
 	require.NoError(t, err)
 
 	fortyTwoConfig := &someConfig{
 		Name:  "forty two",
 		Count: 42,
 		Odd:   false,
+		Nested: nestedConfig{
+			URL: "https://fortytwo",
+		},
 	}
 
 	twentyOne := &someConfig{
 		Name:  "twenty one",
 		Count: 21,
 		Odd:   true,
+		Nested: nestedConfig{
+			URL: "https://twentyone",
+		},
 	}
 
 	err = configDir.Set("fortytwo", &fortyTwoConfig)
@@ -127,7 +198,7 @@ func TestConfigDirSetDumpsAndLoadConfig(t *testing.T) {
 	require.NoError(t, err)
 
 	// Recreating a config dir to show state is loaded from disk
-	configDir, err = NewConfigDir(dir)
+	configDir, err = NewConfigDir(dir, opts...)
 	require.NoError(t, err)
 
 	configs, err := configDir.List()
@@ -146,6 +217,23 @@ func TestConfigDirSetDumpsAndLoadConfig(t *testing.T) {
 	assert.Equal(t, "twenty one", current.Name)
 	assert.Equal(t, 21, current.Count)
 	assert.Equal(t, true, current.Odd)
+	assert.Equal(t, "https://twentyone", current.Nested.URL)
+}
+
+func TestConfigDirSetDumpsAndLoadConfig(t *testing.T) {
+	testConfigDirSetDumpsAndLoadConfig(t)
+}
+
+func TestConfigDirTOMLLoader(t *testing.T) {
+	testConfigDirSetDumpsAndLoadConfig(t, WithConfigDirLoader(TOMLLoader))
+}
+
+func TestConfigDirJSONIndentLoader(t *testing.T) {
+	testConfigDirSetDumpsAndLoadConfig(t, WithConfigDirLoader(JSONIndentLoader))
+
+	b, err := JSONIndentLoader.Marshal(map[string]interface{}{"Name": "prod", "Nested": map[string]string{"URL": "u"}})
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"Name\": \"prod\",\n  \"Nested\": {\n    \"URL\": \"u\"\n  }\n}", string(b))
 }
 
 func TestConfigDirKongUsage(t *testing.T) {
@@ -170,3 +258,566 @@ func TestConfigDirKongUsage(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, dir, cli.path)
 }
+
+func TestConfigDirListInfo(t *testing.T) {
+	type someConfig struct {
+		Name string
+	}
+
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	configDir, err := NewConfigDir(dir)
+	require.NoError(t, err)
+
+	entries, err := configDir.ListInfo()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	require.NoError(t, configDir.Set("prod", &someConfig{Name: "prod"}))
+	require.NoError(t, configDir.Set("staging", &someConfig{Name: "staging"}))
+
+	entries, err = configDir.ListInfo()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.False(t, entry.IsCurrent, "no current config yet")
+	}
+
+	require.NoError(t, configDir.Use("staging"))
+	entries, err = configDir.ListInfo()
+	require.NoError(t, err)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	assert.Equal(t, "prod", entries[0].Name)
+	assert.False(t, entries[0].IsCurrent)
+	assert.Equal(t, "staging", entries[1].Name)
+	assert.True(t, entries[1].IsCurrent)
+	assert.Equal(t, filepath.Join(dir, "staging"+configExt), entries[1].Path)
+	assert.WithinDuration(t, time.Now(), entries[1].ModTime, time.Minute)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, writeConfigList(buf, entries))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "CURRENT  NAME     MODIFIED"), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "         prod     "), lines[1])
+	assert.True(t, strings.HasPrefix(lines[2], "*        staging  "), lines[2])
+
+	// A stray file not matching the allowed names is listed nonetheless.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"+configExt), []byte("{}"), 0666))
+	entries, err = configDir.ListInfo()
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	assert.ElementsMatch(t, []string{"a", "prod", "staging"}, names)
+
+	type cliWithConfigDir struct {
+		ConfigDirCli
+	}
+	var cli cliWithConfigDir
+	parser, err := kong.New(&cli, cli.ConfigDirCli.KongInit(dir))
+	require.NoError(t, err)
+	ctx, err := parser.Parse([]string{"config", "list"})
+	require.NoError(t, err)
+	assert.NoError(t, ctx.Run(&cli.ConfigDirCli))
+}
+
+func TestConfigDirCurrentName(t *testing.T) {
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	configDir, err := NewConfigDir(dir)
+	require.NoError(t, err)
+
+	_, err = configDir.CurrentName()
+	assert.Error(t, err)
+
+	require.NoError(t, configDir.Set("staging", struct{}{}))
+	require.NoError(t, configDir.Use("staging"))
+
+	name, err := configDir.CurrentName()
+	assert.NoError(t, err)
+	assert.Equal(t, "staging", name)
+}
+
+func TestConfigDirError(t *testing.T) {
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	configDir, err := NewConfigDir(dir)
+	require.NoError(t, err)
+
+	dummy := struct{}{}
+	err = configDir.Get("missing", &dummy)
+	var configErr *ConfigDirError
+	require.True(t, errors.As(err, &configErr))
+	assert.Equal(t, "missing", configErr.Name)
+	assert.Equal(t, OpGet, configErr.Op)
+	assert.True(t, os.IsNotExist(errors.Unwrap(configErr.Err)))
+	assert.True(t, strings.HasPrefix(err.Error(), "configdir: missing: get info:"))
+
+	err = configDir.Use("missing")
+	require.True(t, errors.As(err, &configErr))
+	assert.Equal(t, "missing", configErr.Name)
+	assert.Equal(t, OpUse, configErr.Op)
+
+	err = configDir.Set("/", &dummy)
+	require.True(t, errors.As(err, &configErr))
+	assert.Equal(t, OpSet, configErr.Op)
+}
+
+func TestConfigDirCliGetPrecedence(t *testing.T) {
+	type someConfig struct {
+		Name string
+	}
+
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	cli := &ConfigDirCli{path: dir}
+	require.NoError(t, cli.load())
+	for _, name := range []string{"flag", "env", "current"} {
+		require.NoError(t, cli.configDir.Set(name, &someConfig{Name: name}))
+	}
+	require.NoError(t, cli.configDir.Use("current"))
+
+	defer os.Unsetenv(ConfigEnvVar)
+	require.NoError(t, os.Unsetenv(ConfigEnvVar))
+
+	var config someConfig
+	require.NoError(t, cli.Get(&config))
+	assert.Equal(t, "current", config.Name)
+
+	require.NoError(t, os.Setenv(ConfigEnvVar, "env"))
+	require.NoError(t, cli.Get(&config))
+	assert.Equal(t, "env", config.Name)
+
+	cli.ConfigDirFlag.Config = "flag"
+	require.NoError(t, cli.Get(&config))
+	assert.Equal(t, "flag", config.Name)
+}
+
+func TestConfigDirNoCurrentConfig(t *testing.T) {
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	cli := &ConfigDirCli{path: dir}
+	require.NoError(t, cli.load())
+	require.NoError(t, cli.configDir.Set("prod", map[string]string{"Name": "prod"}))
+
+	_, err := cli.configDir.CurrentName()
+	assert.ErrorIs(t, err, ErrNoCurrentConfig)
+	var config map[string]string
+	_, err = cli.configDir.Current(&config)
+	assert.ErrorIs(t, err, ErrNoCurrentConfig)
+
+	defer os.Unsetenv(ConfigEnvVar)
+	require.NoError(t, os.Unsetenv(ConfigEnvVar))
+	err = cli.Get(&config)
+	assert.ErrorIs(t, err, ErrNoCurrentConfig)
+	assert.Contains(t, err.Error(), "--config")
+	assert.Equal(t, 1, strings.Count(err.Error(), "config use"), err.Error())
+
+	// Other failures are not reported as a missing current config.
+	require.NoError(t, os.Mkdir(filepath.Join(dir, currentName), 0755))
+	_, err = cli.configDir.CurrentName()
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrNoCurrentConfig))
+}
+
+func TestConfigDirStdio(t *testing.T) {
+	type someConfig struct {
+		Name string
+	}
+
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	stdout := new(bytes.Buffer)
+	cli := &ConfigDirCli{path: dir, stdin: bytes.NewBufferString(`{"Name": "piped"}`), stdout: stdout}
+	require.NoError(t, cli.load())
+	require.NoError(t, cli.configDir.Set("prod", &someConfig{Name: "prod"}))
+	require.NoError(t, cli.configDir.Use("prod"))
+
+	// Dump emits the file verbatim.
+	buf := new(bytes.Buffer)
+	require.NoError(t, cli.configDir.Dump("prod", buf))
+	raw, err := os.ReadFile(filepath.Join(dir, "prod"+configExt))
+	require.NoError(t, err)
+	assert.Equal(t, raw, buf.Bytes())
+	assert.Error(t, cli.configDir.Dump("missing", buf))
+
+	var config someConfig
+	require.NoError(t, cli.configDir.LoadFrom("-", bytes.NewBufferString(`{"Name": "reader"}`), &config))
+	assert.Equal(t, "reader", config.Name)
+	var configErr *ConfigDirError
+	assert.True(t, errors.As(cli.configDir.LoadFrom("-", bytes.NewBufferString(`{`), &config), &configErr))
+
+	// "-" reads stdin and writes stdout, without touching the directory.
+	cli.ConfigDirFlag.Config = StdioConfig
+	require.NoError(t, cli.Get(&config))
+	assert.Equal(t, "piped", config.Name)
+
+	require.NoError(t, cli.Set(&someConfig{Name: "out"}, true))
+	var out someConfig
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &out))
+	assert.Equal(t, "out", out.Name)
+
+	list, err := cli.configDir.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod"}, list)
+	current, err := cli.configDir.CurrentName()
+	require.NoError(t, err)
+	assert.Equal(t, "prod", current)
+}
+
+func TestConfigShowCmd(t *testing.T) {
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	type cliWithConfigDir struct {
+		ConfigDirCli
+	}
+	var cli cliWithConfigDir
+	stdout := new(bytes.Buffer)
+	cli.stdout = stdout
+	parser, err := kong.New(&cli, cli.ConfigDirCli.KongInit(dir))
+	require.NoError(t, err)
+	require.NoError(t, cli.load())
+	require.NoError(t, cli.configDir.Set("prod", map[string]string{"Name": "prod"}))
+	require.NoError(t, cli.configDir.Set("staging", map[string]string{"Name": "staging"}))
+
+	ctx, err := parser.Parse([]string{"config", "show"})
+	require.NoError(t, err)
+	assert.ErrorIs(t, ctx.Run(&cli.ConfigDirCli), ErrNoCurrentConfig)
+
+	require.NoError(t, cli.configDir.Use("prod"))
+	ctx, err = parser.Parse([]string{"config", "show"})
+	require.NoError(t, err)
+	require.NoError(t, ctx.Run(&cli.ConfigDirCli))
+	assert.JSONEq(t, `{"Name": "prod"}`, stdout.String())
+
+	stdout.Reset()
+	ctx, err = parser.Parse([]string{"config", "show", "staging"})
+	require.NoError(t, err)
+	require.NoError(t, ctx.Run(&cli.ConfigDirCli))
+	assert.JSONEq(t, `{"Name": "staging"}`, stdout.String())
+}
+
+func TestConfigDirRename(t *testing.T) {
+	type someConfig struct {
+		Name string
+	}
+
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	configDir, err := NewConfigDir(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, configDir.Set("codename", &someConfig{Name: "client"}))
+	require.NoError(t, configDir.Set("other", &someConfig{Name: "other"}))
+	require.NoError(t, configDir.Use("codename"))
+
+	assert.Error(t, configDir.Rename("codename", "/etc/passwd"))
+	assert.Error(t, configDir.Rename("codename", "../escaped"))
+	_, err = os.Stat(filepath.Join(dir, "..", "escaped"+configExt))
+	assert.True(t, os.IsNotExist(err), "renamed out of the directory")
+	assert.ErrorIs(t, configDir.Rename("codename", "other"), os.ErrExist)
+
+	require.NoError(t, configDir.Rename("codename", "client"))
+
+	list, err := configDir.List()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"client", "other"}, list)
+
+	var current someConfig
+	info, err := configDir.Current(&current)
+	require.NoError(t, err)
+	assert.Equal(t, "client", info.Name)
+	assert.Equal(t, "client", current.Name)
+
+	require.NoError(t, configDir.Rename("client", "other", WithOverwrite()))
+	list, err = configDir.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other"}, list)
+}
+
+func TestConfigDirCopy(t *testing.T) {
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	configDir, err := NewConfigDir(dir)
+	require.NoError(t, err)
+
+	// Hand formatted to assert the copy is verbatim.
+	prod := []byte("{\n  \"Name\": \"prod\"\n}\n")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "prod"+configExt), prod, 0666))
+	require.NoError(t, configDir.Set("other", map[string]string{"Name": "other"}))
+	require.NoError(t, configDir.Use("prod"))
+
+	assert.Error(t, configDir.Copy("prod", "/etc/passwd"))
+	assert.Error(t, configDir.Copy("prod", "../escaped"))
+	_, err = os.Stat(filepath.Join(dir, "..", "escaped"+configExt))
+	assert.True(t, os.IsNotExist(err), "copied out of the directory")
+	assert.Error(t, configDir.Copy("../prod", "staging"))
+	assert.Error(t, configDir.Copy("missing", "staging"))
+	assert.ErrorIs(t, configDir.Copy("prod", "other"), os.ErrExist)
+
+	require.NoError(t, configDir.Copy("prod", "staging"))
+	content, err := os.ReadFile(filepath.Join(dir, "staging"+configExt))
+	require.NoError(t, err)
+	assert.Equal(t, prod, content)
+
+	require.NoError(t, configDir.Copy("prod", "other", WithOverwrite()))
+	content, err = os.ReadFile(filepath.Join(dir, "other"+configExt))
+	require.NoError(t, err)
+	assert.Equal(t, prod, content)
+
+	current, err := configDir.CurrentName()
+	require.NoError(t, err)
+	assert.Equal(t, "prod", current)
+
+	// Through the cli.
+	type cliWithConfigDir struct {
+		ConfigDirCli
+	}
+	var cli cliWithConfigDir
+	parser, err := kong.New(&cli, cli.ConfigDirCli.KongInit(dir))
+	require.NoError(t, err)
+
+	ctx, err := parser.Parse([]string{"config", "copy", "staging", "devel"})
+	require.NoError(t, err)
+	require.NoError(t, ctx.Run(&cli.ConfigDirCli))
+	list, err := configDir.List()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"prod", "staging", "other", "devel"}, list)
+
+	ctx, err = parser.Parse([]string{"config", "copy", "staging", "devel"})
+	require.NoError(t, err)
+	assert.ErrorIs(t, ctx.Run(&cli.ConfigDirCli), os.ErrExist)
+
+	ctx, err = parser.Parse([]string{"config", "copy", "--overwrite", "staging", "devel"})
+	require.NoError(t, err)
+	assert.NoError(t, ctx.Run(&cli.ConfigDirCli))
+}
+
+func TestConfigDirReadOnly(t *testing.T) {
+	type someConfig struct {
+		Name string
+	}
+
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	writable, err := NewConfigDir(dir)
+	require.NoError(t, err)
+	require.NoError(t, writable.Set("codename", &someConfig{Name: "client"}))
+	require.NoError(t, writable.Use("codename"))
+
+	configDir, err := NewConfigDir(dir, WithReadOnly(true))
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, configDir.Set("other", &someConfig{Name: "other"}), ErrReadOnly)
+	assert.ErrorIs(t, configDir.Use("missing"), ErrReadOnly)
+	assert.ErrorIs(t, configDir.Rename("codename", "other"), ErrReadOnly)
+	assert.ErrorIs(t, configDir.Copy("codename", "other"), ErrReadOnly)
+
+	list, err := configDir.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"codename"}, list, "writes must not touch the filesystem")
+
+	var cfg someConfig
+	require.NoError(t, configDir.Get("codename", &cfg))
+	assert.Equal(t, "client", cfg.Name)
+
+	info, err := configDir.Current(&cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "codename", info.Name)
+}
+
+func TestConfigDirValidator(t *testing.T) {
+	type someConfig struct {
+		URL string
+	}
+
+	errMissingURL := errors.New("missing url")
+	validator := func(cfg interface{}) error {
+		if cfg.(*someConfig).URL == "" {
+			return errMissingURL
+		}
+		return nil
+	}
+
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	configDir, err := NewConfigDir(dir, WithConfigValidator(validator))
+	require.NoError(t, err)
+
+	require.NoError(t, configDir.Set("prod", &someConfig{URL: "https://example.com"}))
+
+	err = configDir.Set("prod", &someConfig{})
+	assert.ErrorIs(t, err, errMissingURL)
+	var configErr *ConfigDirError
+	require.ErrorAs(t, err, &configErr)
+	assert.Equal(t, OpSet, configErr.Op)
+
+	var cfg someConfig
+	require.NoError(t, configDir.Get("prod", &cfg))
+	assert.Equal(t, "https://example.com", cfg.URL, "the file must be left untouched")
+
+	assert.ErrorIs(t, configDir.Set("other", &someConfig{}), errMissingURL)
+	list, err := configDir.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod"}, list)
+}
+
+func TestConfigDirMergeOnSet(t *testing.T) {
+	type oldConfig struct {
+		URL  string            `json:"url"`
+		Auth map[string]string `json:"auth"`
+	}
+
+	for _, merge := range []bool{false, true} {
+		dir := requireTempDir(t)
+		defer os.RemoveAll(dir)
+
+		var opts []ConfigDirOption
+		if merge {
+			opts = append(opts, WithMergeOnSet())
+		}
+		configDir, err := NewConfigDir(dir, opts...)
+		require.NoError(t, err)
+
+		// A new configuration is written as is.
+		require.NoError(t, configDir.Set("fresh", &oldConfig{URL: "a"}))
+		raw, err := os.ReadFile(filepath.Join(dir, "fresh"+configExt))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"url": "a", "auth": null}`, string(raw))
+
+		// Written by a newer version knowing more fields.
+		path := filepath.Join(dir, "prod"+configExt)
+		require.NoError(t, os.WriteFile(path, []byte(`{"url": "a", "timeout": 3, "auth": {"user": "u", "token": "t"}}`), 0666))
+
+		var cfg oldConfig
+		require.NoError(t, configDir.Get("prod", &cfg))
+		cfg.URL = "b"
+		cfg.Auth["user"] = "v"
+		require.NoError(t, configDir.Set("prod", &cfg))
+
+		raw, err = os.ReadFile(path)
+		require.NoError(t, err)
+		if merge {
+			assert.JSONEq(t, `{"url": "b", "timeout": 3, "auth": {"user": "v", "token": "t"}}`, string(raw))
+		} else {
+			assert.JSONEq(t, `{"url": "b", "auth": {"user": "v", "token": "t"}}`, string(raw))
+		}
+	}
+}
+
+func TestConfigDirMergeOnSetIsLossless(t *testing.T) {
+	type someConfig struct {
+		ID       int64  `json:"id"`
+		Optional string `json:"opt,omitempty"`
+		Nested   struct {
+			Optional string `json:"opt,omitempty"`
+		} `json:"nested"`
+	}
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	for _, loader := range []ConfigLoader{JSONLoader, NewEncryptedLoader(JSONLoader, key)} {
+		dir := requireTempDir(t)
+		defer os.RemoveAll(dir)
+
+		configDir, err := NewConfigDir(dir, WithConfigDirLoader(loader), WithMergeOnSet())
+		require.NoError(t, err)
+
+		existing := map[string]interface{}{
+			"id":      json.Number("9007199254740993"),
+			"opt":     "x",
+			"big":     json.Number("9007199254740995"),
+			"unknown": "u",
+			"nested":  map[string]interface{}{"opt": "y", "unknown": "v"},
+		}
+		require.NoError(t, configDir.Set("prod", existing))
+
+		var cfg someConfig
+		require.NoError(t, configDir.Get("prod", &cfg))
+		assert.Equal(t, int64(9007199254740993), cfg.ID)
+		cfg.ID++
+		// Cleared omitempty fields are removed.
+		cfg.Optional, cfg.Nested.Optional = "", ""
+		require.NoError(t, configDir.Set("prod", &cfg))
+
+		raw, err := os.ReadFile(filepath.Join(dir, "prod"+configExt))
+		require.NoError(t, err)
+		merged, err := unmarshalConfigMap(loader, raw)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"id":      json.Number("9007199254740994"),
+			"big":     json.Number("9007199254740995"),
+			"unknown": "u",
+			"nested":  map[string]interface{}{"unknown": "v"},
+		}, merged)
+
+		cfg = someConfig{}
+		require.NoError(t, configDir.Get("prod", &cfg))
+		assert.Equal(t, int64(9007199254740994), cfg.ID)
+	}
+}
+
+func TestConfigDirEncryptedLoader(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	testConfigDirSetDumpsAndLoadConfig(t, WithConfigDirLoader(NewEncryptedLoader(JSONLoader, key)))
+	testConfigDirSetDumpsAndLoadConfig(t, WithConfigDirLoader(NewEncryptedLoader(TOMLLoader, key)))
+
+	type someConfig struct {
+		Secret string
+	}
+
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	configDir, err := NewConfigDir(dir, WithConfigDirLoader(NewEncryptedLoader(JSONLoader, key)))
+	require.NoError(t, err)
+	require.NoError(t, configDir.Set("prod", &someConfig{Secret: "hunter2"}))
+
+	raw, err := os.ReadFile(dir + "/prod" + configExt)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "hunter2")
+
+	wrongKey := []byte("fedcba9876543210fedcba9876543210")
+	configDir, err = NewConfigDir(dir, WithConfigDirLoader(NewEncryptedLoader(JSONLoader, wrongKey)))
+	require.NoError(t, err)
+
+	var config someConfig
+	assert.ErrorIs(t, configDir.Get("prod", &config), ErrConfigDecryption)
+	assert.Empty(t, config.Secret)
+}
+
+func TestConfigDirXdgConfigPathFor(t *testing.T) {
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	defer xdg.Reload()
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	require.NoError(t, os.Setenv("XDG_CONFIG_HOME", dir))
+	xdg.Reload()
+
+	configDir, err := NewConfigDir("", WithXdgConfigPathFor("optable", "cli"))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "optable", "cli"), configDir.Path())
+
+	stat, err := os.Stat(configDir.Path())
+	require.NoError(t, err)
+	assert.True(t, stat.IsDir())
+
+	for _, invalid := range [][2]string{{"", "cli"}, {"optable", ""}, {"..", "cli"}, {"optable", "a/b"}} {
+		_, err := NewConfigDir("", WithXdgConfigPathFor(invalid[0], invalid[1]))
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ConfigProblem describes a configuration found invalid by Validate.
+type ConfigProblem struct {
+	Name string
+	Err  error
+}
+
+// ErrDanglingCurrent is the error of the ConfigProblem reported by Validate
+// when the current configuration pointer references a missing configuration.
+var ErrDanglingCurrent = errors.New("current configuration is missing, see 'config use'")
+
+// Validate attempts to load every configuration with the loader and reports
+// those which can't be, e.g. a hand edited file with a syntax error. All the
+// configurations are checked, a corrupt one doesn't stop the validation. A
+// current configuration pointer referencing a missing configuration is
+// reported with ErrDanglingCurrent. The returned error is reserved to failures
+// listing the configurations.
+//
+// The concrete configuration type is unknown, configurations are decoded into
+// a generic map, thus only the syntax is validated, see WithConfigValidator
+// for validating the content.
+func (c *ConfigDir) Validate() ([]ConfigProblem, error) {
+	names, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []ConfigProblem
+	exists := make(map[string]bool, len(names))
+	for _, name := range names {
+		exists[name] = true
+		if err := c.Get(name, &map[string]interface{}{}); err != nil {
+			problems = append(problems, ConfigProblem{Name: name, Err: err})
+		}
+	}
+
+	// A missing pointer is not a problem, no configuration is in use yet.
+	if current, err := c.CurrentName(); err == nil && !exists[current] {
+		problems = append(problems, ConfigProblem{Name: current, Err: ErrDanglingCurrent})
+	}
+
+	return problems, nil
+}
+
+type (
+	ConfigDoctorCmd struct {
+	}
+)
+
+func (u *ConfigDoctorCmd) BeforeResolve(c *ConfigDirCli) (err error) {
+	return c.load()
+}
+
+func (u *ConfigDoctorCmd) Run(c *ConfigDirCli) error {
+	problems, err := c.configDir.Validate()
+	if err != nil {
+		return fmt.Errorf("Failed validating configs: %w", err)
+	}
+
+	return writeConfigProblems(os.Stdout, problems)
+}
+
+// writeConfigProblems prints one problem per line and fails if there's any
+// such that the exit status reflects the diagnostic.
+func writeConfigProblems(w io.Writer, problems []ConfigProblem) error {
+	for _, problem := range problems {
+		fmt.Fprintf(w, "%s: %v\n", problem.Name, problem.Err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Found %d invalid config(s)", len(problems))
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigDirValidate(t *testing.T) {
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	configDir, err := NewConfigDir(dir)
+	require.NoError(t, err)
+
+	problems, err := configDir.Validate()
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	require.NoError(t, configDir.Set("prod", map[string]string{"Name": "prod"}))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken"+configExt), []byte(`{"Name": `), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "empty"+configExt), nil, 0666))
+
+	problems, err = configDir.Validate()
+	require.NoError(t, err)
+	require.Len(t, problems, 2)
+	assert.ElementsMatch(t, []string{"broken", "empty"}, []string{problems[0].Name, problems[1].Name})
+
+	var configErr *ConfigDirError
+	assert.ErrorAs(t, problems[0].Err, &configErr)
+
+	// The current pointer references a config removed behind our back.
+	require.NoError(t, configDir.Set("gone", map[string]string{"Name": "gone"}))
+	require.NoError(t, configDir.Use("gone"))
+	require.NoError(t, os.Remove(filepath.Join(dir, "gone"+configExt)))
+
+	problems, err = configDir.Validate()
+	require.NoError(t, err)
+	require.Len(t, problems, 3)
+	assert.Equal(t, ConfigProblem{Name: "gone", Err: ErrDanglingCurrent}, problems[2])
+
+	buf := new(bytes.Buffer)
+	assert.Error(t, writeConfigProblems(buf, problems))
+	assert.Contains(t, buf.String(), "gone: "+ErrDanglingCurrent.Error())
+	assert.NoError(t, writeConfigProblems(buf, nil))
+}
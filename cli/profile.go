@@ -3,7 +3,21 @@
 package cli
 
 import (
-	"github.com/pkg/profile"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	httppprof "net/http/pprof"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strings"
+	"sync"
+	"time"
 )
 
 type (
@@ -12,14 +26,26 @@ type (
 	// hidden from the help message such that we can use it in public cli.
 	//
 	// The supported values are:
-	//   - "cpu":    Enables CPU profiling.
-	//   - "memory": Enables heap memory profiling.
-	//   - "block":  Enables block (contention) profiling.
-	//   - "mutex":  Enables mutex profiling.
-	//   - "trace":  Enables trace profiling.
+	//   - "cpu":          Enables CPU profiling.
+	//   - "memory":       Enables heap memory profiling.
+	//   - "block":        Enables block (contention) profiling.
+	//   - "mutex":        Enables mutex profiling.
+	//   - "trace":        Enables trace profiling.
+	//   - "goroutine":    Dumps the goroutines stacks when stopped, e.g. to
+	//                     chase goroutine leaks.
+	//   - "threadcreate": Dumps the stacks which led to the creation of OS
+	//                     threads when stopped.
+	//   - "http":         Serves live profiles with net/http/pprof on ProfilingAddr.
+	//
+	// Multiple values can be combined with commas, e.g. `cpu,mutex`.
 	//
 	// The profiling file path will be shown to stderr and can be opened with
-	// `go tool pprof $file` or `go tool pprof -http localhost:8080 $file`
+	// `go tool pprof $file` or `go tool pprof -http localhost:8080 $file`. The
+	// file is written in ProfilingDir, or the working directory when empty,
+	// e.g. point it to /tmp when the working directory is read-only.
+	//
+	// The "http" mode suits long-running services, the profiles are fetched on
+	// demand, e.g. `go tool pprof http://localhost:6060/debug/pprof/heap`.
 	//
 	// In order to enable profiling, one should use the command like this:
 	// ```
@@ -27,26 +53,206 @@ type (
 	// defer stopProfiling()
 	// ```
 	ProfilingFlag struct {
-		Profiling string `opt:"" hidden:"true" default:""`
+		Profiling     string `opt:"" hidden:"true" default:""`
+		ProfilingDir  string `opt:"" hidden:"true" default:""`
+		ProfilingAddr string `opt:"" hidden:"true" default:"localhost:6060"`
 	}
 )
 
 // Start starts the profiling operation. It returns a function that needs to be
 // called when the profiling should stop.
+//
+// Multiple profiles can be enabled at once with a comma-separated list, e.g.
+// `cpu,mutex`. Each profile is written in its own file and the returned
+// function stops them in reverse order. A profile failing to start is
+// reported to stderr and skipped.
+//
+// Like github.com/pkg/profile, an interrupt (SIGINT) stops the profiles, such
+// that their files are complete, and exits the process with status 0, since a
+// deferred stop would not run. The hook is removed by the returned function and
+// only installed when profiling is enabled.
 func (p *ProfilingFlag) Start() func() {
-	path := profile.ProfilePath(".")
-	switch p.Profiling {
+	dir := p.ProfilingDir
+	if dir == "" {
+		dir = "."
+	}
+
+	var (
+		stops   []func()
+		started = make(map[string]bool)
+	)
+	for _, kind := range strings.Split(p.Profiling, ",") {
+		kind = strings.TrimSpace(kind)
+		if kind == "" || started[kind] {
+			continue
+		}
+		started[kind] = true
+
+		var stop func()
+		switch kind {
+		case "http":
+			stop = startHTTPProfiling(p.ProfilingAddr)
+		default:
+			stop = startFileProfiling(kind, dir)
+		}
+		stops = append(stops, stop)
+	}
+
+	if len(stops) == 0 {
+		return func() {}
+	}
+
+	var once sync.Once
+	stopAll := func() {
+		once.Do(func() {
+			for i := len(stops) - 1; i >= 0; i-- {
+				stops[i]()
+			}
+		})
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-interrupts:
+			log.Printf("profile: caught interrupt, stopping profiles")
+			stopAll()
+			profilingExit(0)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(interrupts)
+		close(done)
+		stopAll()
+	}
+}
+
+// profilingExit exits the process once the profiles are stopped on
+// interrupt, replaced in tests.
+var profilingExit = os.Exit
+
+// memProfileRate is the sampling rate of the memory profiling, a sample is
+// recorded every 4KiB allocated instead of the default 512KiB.
+const memProfileRate = 4096
+
+// startFileProfiling starts the profile `kind` written in `dir` when stopped.
+// Unlike github.com/pkg/profile, which only allows a single profile per
+// process, profiles can run concurrently. The file names are distinct per
+// kind, thus they never collide.
+func startFileProfiling(kind, dir string) func() {
+	var (
+		file  string
+		start func(w io.Writer) error
+		stop  func(w io.Writer)
+	)
+
+	lookup := func(name string) func(w io.Writer) {
+		return func(w io.Writer) { _ = pprof.Lookup(name).WriteTo(w, 0) }
+	}
+	noopStart := func(io.Writer) error { return nil }
+
+	switch kind {
 	case "cpu":
-		return profile.Start(path, profile.CPUProfile).Stop
+		file, start, stop = "cpu.pprof", pprof.StartCPUProfile, func(io.Writer) { pprof.StopCPUProfile() }
 	case "memory":
-		return profile.Start(path, profile.MemProfile).Stop
+		previous := runtime.MemProfileRate
+		file = "mem.pprof"
+		start = func(io.Writer) error {
+			runtime.MemProfileRate = memProfileRate
+			return nil
+		}
+		stop = func(w io.Writer) {
+			lookup("heap")(w)
+			runtime.MemProfileRate = previous
+		}
 	case "block":
-		return profile.Start(path, profile.BlockProfile).Stop
+		file = "block.pprof"
+		start = func(io.Writer) error {
+			runtime.SetBlockProfileRate(1)
+			return nil
+		}
+		stop = func(w io.Writer) {
+			lookup("block")(w)
+			runtime.SetBlockProfileRate(0)
+		}
 	case "mutex":
-		return profile.Start(path, profile.MutexProfile).Stop
+		file = "mutex.pprof"
+		start = func(io.Writer) error {
+			runtime.SetMutexProfileFraction(1)
+			return nil
+		}
+		stop = func(w io.Writer) {
+			lookup("mutex")(w)
+			runtime.SetMutexProfileFraction(0)
+		}
 	case "trace":
-		return profile.Start(path, profile.TraceProfile).Stop
+		file, start, stop = "trace.out", trace.Start, func(io.Writer) { trace.Stop() }
+	case "goroutine":
+		file, start, stop = "goroutine.pprof", noopStart, lookup("goroutine")
+	case "threadcreate":
+		file, start, stop = "threadcreation.pprof", noopStart, lookup("threadcreate")
 	default:
 		return func() {}
 	}
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		log.Printf("profile: %s profiling disabled, failed creating directory: %v", kind, err)
+		return func() {}
+	}
+
+	path := filepath.Join(dir, file)
+	f, err := os.Create(path)
+	if err != nil {
+		log.Printf("profile: %s profiling disabled, failed creating file: %v", kind, err)
+		return func() {}
+	}
+
+	if err := start(f); err != nil {
+		f.Close()
+		log.Printf("profile: %s profiling disabled, failed starting: %v", kind, err)
+		return func() {}
+	}
+	log.Printf("profile: %s profiling enabled, %s", kind, path)
+
+	return func() {
+		stop(f)
+		f.Close()
+		log.Printf("profile: %s profiling disabled, %s", kind, path)
+	}
+}
+
+// profilingShutdownTimeout bounds the wait for in-flight profiles, e.g. a CPU
+// profile lasting 30 seconds, when stopping the http profiling.
+const profilingShutdownTimeout = 5 * time.Second
+
+func startHTTPProfiling(addr string) func() {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("profile: http profiling disabled, failed listening: %v", err)
+		return func() {}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+	server := &http.Server{Handler: mux}
+
+	log.Printf("profile: http profiling enabled, http://%s/debug/pprof/", l.Addr())
+	go func() { _ = server.Serve(l) }()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), profilingShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			_ = server.Close()
+		}
+		log.Printf("profile: http profiling disabled")
+	}
 }
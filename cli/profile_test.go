@@ -0,0 +1,90 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package cli
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfilingHTTP(t *testing.T) {
+	// Reserve a free port.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	flag := ProfilingFlag{Profiling: "http", ProfilingAddr: addr}
+	stop := flag.Start()
+
+	resp, err := http.Get("http://" + addr + "/debug/pprof/heap?debug=1")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	stop()
+	_, err = http.Get("http://" + addr + "/debug/pprof/")
+	assert.Error(t, err, "server should be stopped")
+}
+
+func TestProfilingDir(t *testing.T) {
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	flag := ProfilingFlag{Profiling: "memory", ProfilingDir: dir}
+	flag.Start()()
+
+	_, err := os.Stat(filepath.Join(dir, "mem.pprof"))
+	assert.NoError(t, err)
+}
+
+func TestProfilingMultiple(t *testing.T) {
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	flag := ProfilingFlag{Profiling: "cpu, memory,mutex,cpu,unknown,goroutine,threadcreate", ProfilingDir: dir}
+	flag.Start()()
+
+	for _, file := range []string{"cpu.pprof", "mem.pprof", "mutex.pprof", "goroutine.pprof", "threadcreation.pprof"} {
+		stat, err := os.Stat(filepath.Join(dir, file))
+		if assert.NoError(t, err, file) {
+			assert.NotZero(t, stat.Size(), file)
+		}
+	}
+
+	// Profiles can be started again once stopped.
+	flag.Start()()
+}
+
+func TestProfilingInterrupt(t *testing.T) {
+	dir := requireTempDir(t)
+	defer os.RemoveAll(dir)
+
+	exited := make(chan int, 1)
+	profilingExit = func(code int) { exited <- code }
+	defer func() { profilingExit = os.Exit }()
+
+	flag := ProfilingFlag{Profiling: "goroutine", ProfilingDir: dir}
+	stop := flag.Start()
+	defer stop()
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+	select {
+	case code := <-exited:
+		assert.Equal(t, 0, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the interrupt hook didn't run")
+	}
+
+	stat, err := os.Stat(filepath.Join(dir, "goroutine.pprof"))
+	require.NoError(t, err)
+	assert.NotZero(t, stat.Size(), "stopped before exiting")
+}
@@ -4,6 +4,7 @@ package errors
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 )
 
@@ -32,9 +33,44 @@ func (e *PositionalError) Unwrap() error {
 	return e.err
 }
 
-// Errors is an error that wrap two or more errors. The downside of batching
-// many errors is that unwrap will only return the first error. Use the
-// `Errors` method to extract all errors.
+// NewPositionalErrors batches the failures of a bulk operation, usually
+// created with NewPositionalError, into a single error like NewErrors. nil
+// errors are dropped. Use PositionsOf to extract the failed positions.
+func NewPositionalErrors(errs ...error) error {
+	return NewErrors(errs...)
+}
+
+// PositionsOf returns the positions of all the PositionalError found in err,
+// following wrapped errors and the errors of *Errors, in order of occurrence.
+// The errors wrapped by a PositionalError are not inspected since their
+// positions would be relative to another input. Returns nil if none is found.
+func PositionsOf(err error) []int {
+	var positions []int
+
+	var walk func(err error)
+	walk = func(err error) {
+		for err != nil {
+			switch e := err.(type) {
+			case *PositionalError:
+				positions = append(positions, e.pos)
+				return
+			case *Errors:
+				for _, err := range e.errs {
+					walk(err)
+				}
+				return
+			}
+			err = errors.Unwrap(err)
+		}
+	}
+	walk(err)
+
+	return positions
+}
+
+// Errors is an error that wrap two or more errors. Unwrap only returns the
+// first error, but errors.Is and errors.As inspect all the wrapped errors, see
+// the Is and As methods. Use the `Errors` method to extract all errors.
 type Errors struct {
 	errs []error
 }
@@ -59,6 +95,29 @@ func (e *Errors) Unwrap() error {
 	return e.errs[0]
 }
 
+// Is reports whether any of the wrapped errors matches target, see errors.Is.
+//
+// This is equivalent to the Go 1.20 `Unwrap() []error` method, which can't
+// coexist with Unwrap and isn't supported by older toolchains.
+func (e *Errors) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first wrapped error matching target, see errors.As.
+func (e *Errors) As(target interface{}) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 func NewErrors(errs ...error) error {
 	var errors []error
 	for _, err := range errs {
@@ -76,3 +135,58 @@ func NewErrors(errs ...error) error {
 		return &Errors{errors}
 	}
 }
+
+// Append accumulates errors into `into`, e.g. within a loop of bulk
+// operations. nil errors are dropped and *Errors, including `into`, are
+// flattened such that the result never nests *Errors. Like NewErrors, it
+// returns nil when there's no error and a single error as is.
+//
+//	var err error
+//	for _, item := range items {
+//		err = errors.Append(err, process(item))
+//	}
+func Append(into error, errs ...error) error {
+	var flattened []error
+	collect := func(err error) { flattened = append(flattened, err) }
+
+	walkErrors(into, collect)
+	for _, err := range errs {
+		walkErrors(err, collect)
+	}
+
+	return NewErrors(flattened...)
+}
+
+// walkErrors calls fn on each non-nil error of err, flattening *Errors.
+func walkErrors(err error, fn func(error)) {
+	if errs, ok := err.(*Errors); ok {
+		for _, err := range errs.errs {
+			walkErrors(err, fn)
+		}
+		return
+	}
+	if err != nil {
+		fn(err)
+	}
+}
+
+// ErrorMessages flattens an error into the list of its messages. An *Errors
+// (and the *Errors it contains) is flattened into the messages of its errors,
+// any other error yields its own message. Messages are deduplicated and keep
+// the order of their first occurrence. Returns nil on a nil error.
+func ErrorMessages(err error) []string {
+	var (
+		messages []string
+		seen     = make(map[string]bool)
+	)
+
+	walkErrors(err, func(err error) {
+		msg := err.Error()
+		if !seen[msg] {
+			seen[msg] = true
+			messages = append(messages, msg)
+		}
+	})
+
+	return messages
+}
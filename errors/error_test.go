@@ -4,6 +4,7 @@ package errors
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,6 +30,26 @@ func TestPositionalError(t *testing.T) {
 	}
 }
 
+func TestPositionalErrors(t *testing.T) {
+	assert.Nil(t, NewPositionalErrors(nil, nil))
+	assert.Nil(t, PositionsOf(nil))
+	assert.Nil(t, PositionsOf(myErr))
+
+	err := NewPositionalErrors(NewPositionalError(1, myErr), nil, NewPositionalError(4, myErr))
+	assert.Equal(t, []int{1, 4}, PositionsOf(err))
+	assert.ErrorIs(t, err, myErr)
+
+	// Wrapped and nested errors are followed.
+	err = fmt.Errorf("bulk insert: %w", NewErrors(
+		NewPositionalError(0, myErr),
+		fmt.Errorf("context: %w", NewPositionalError(2, myErr)),
+		NewErrors(NewPositionalError(7, myErr), myErr),
+	))
+	assert.Equal(t, []int{0, 2, 7}, PositionsOf(err))
+
+	assert.Equal(t, []int{3}, PositionsOf(NewPositionalErrors(NewPositionalError(3, myErr))))
+}
+
 func TestErrors(t *testing.T) {
 	assert.Nil(t, NewErrors(), "NewErrors should return nil on empty array")
 	assert.Nil(t, NewErrors(nil, nil), "NewErrors should return nil when errors only contain nils")
@@ -42,3 +63,57 @@ func TestErrors(t *testing.T) {
 		assert.Equal(t, myErr, errs.Unwrap())
 	}
 }
+
+func TestErrorsIsAndAs(t *testing.T) {
+	first, second, third := errors.New("first"), errors.New("second"), errors.New("third")
+
+	err := NewErrors(first, second, fmt.Errorf("wrapped: %w", third))
+	assert.ErrorIs(t, err, first)
+	assert.ErrorIs(t, err, second)
+	assert.ErrorIs(t, err, third)
+	assert.NotErrorIs(t, err, myErr)
+
+	// Nested batches are inspected as well.
+	assert.ErrorIs(t, NewErrors(first, NewErrors(second, myErr)), myErr)
+
+	var posErr *PositionalError
+	err = NewErrors(first, second, NewPositionalError(3, third))
+	if assert.ErrorAs(t, err, &posErr) {
+		assert.Equal(t, 3, posErr.Position())
+	}
+	assert.False(t, errors.As(NewErrors(first, second), &posErr))
+}
+
+func TestAppend(t *testing.T) {
+	first, second, third := errors.New("first"), errors.New("second"), errors.New("third")
+
+	assert.Nil(t, Append(nil))
+	assert.Nil(t, Append(nil, nil, nil))
+	assert.Equal(t, first, Append(nil, first))
+	assert.Equal(t, first, Append(first, nil))
+
+	var err error
+	for _, e := range []error{first, nil, second} {
+		err = Append(err, e)
+	}
+	var errs *Errors
+	if assert.ErrorAs(t, err, &errs) {
+		assert.Equal(t, []error{first, second}, errs.Errors())
+	}
+
+	// Nested *Errors are flattened.
+	err = Append(NewErrors(first, second), NewErrors(third, NewErrors(myErr, first)))
+	if assert.ErrorAs(t, err, &errs) {
+		assert.Equal(t, []error{first, second, third, myErr, first}, errs.Errors())
+	}
+}
+
+func TestErrorMessages(t *testing.T) {
+	assert.Nil(t, ErrorMessages(nil))
+	assert.Equal(t, []string{"mockErr"}, ErrorMessages(myErr))
+
+	a, b, c := errors.New("a"), errors.New("b"), errors.New("c")
+	nested := NewErrors(b, NewErrors(a, c), myErr)
+	err := NewErrors(a, nested, b, c)
+	assert.Equal(t, []string{"a", "b", "c", "mockErr"}, ErrorMessages(err))
+}
@@ -3,16 +3,22 @@ module github.com/optable/optable-pkglib
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.0.0
 	github.com/adrg/xdg v0.3.3
 	github.com/alecthomas/kong v0.2.18-0.20210621110843-8b2821cc246b
 	github.com/grpc-ecosystem/go-grpc-middleware/providers/openmetrics/v2 v2.0.0-20210817165541-f8899ff9df52
 	github.com/grpc-ecosystem/go-grpc-middleware/providers/zerolog/v2 v2.0.0-rc.2.0.20210817165541-f8899ff9df52
 	github.com/grpc-ecosystem/go-grpc-middleware/v2 v2.0.0-rc.2.0.20210817165541-f8899ff9df52
-	github.com/pkg/profile v1.6.0
+	github.com/improbable-eng/grpc-web v0.15.0
 	github.com/prometheus/client_golang v1.9.0
 	github.com/rs/zerolog v1.23.0
 	github.com/soheilhy/cmux v0.1.5
 	github.com/stretchr/testify v1.7.0
+	go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc v0.25.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	golang.org/x/net v0.0.0-20210805182204-aaa1db679c0d
 	golang.org/x/sync v0.0.0-20201020160332-67f06af15bc9
 	google.golang.org/grpc v1.42.0
 )
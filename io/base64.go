@@ -0,0 +1,66 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	perrors "github.com/optable/optable-pkglib/errors"
+)
+
+// NewBase64FrameWriter returns a FrameWriter encoding each payload with the
+// standard base64 encoding and delimiting it with newlines, see
+// NewNewlineDelimitedFrameWriter. This allows binary payloads, including
+// newlines, to go through systems mangling non-printable bytes.
+func NewBase64FrameWriter(w io.Writer) FrameWriter {
+	return &base64FrameWriter{lines: &newlineDelimitedFrameWriter{w: w, first: true}}
+}
+
+type base64FrameWriter struct {
+	lines *newlineDelimitedFrameWriter
+	buf   []byte
+}
+
+func (b *base64FrameWriter) Write(payload []byte) (int, error) {
+	n := base64.StdEncoding.EncodedLen(len(payload))
+	if cap(b.buf) < n {
+		b.buf = make([]byte, n)
+	}
+	b.buf = b.buf[:n]
+	base64.StdEncoding.Encode(b.buf, payload)
+	return b.lines.Write(b.buf)
+}
+
+func (b *base64FrameWriter) Reset(w io.Writer) {
+	b.lines.Reset(w)
+}
+
+// NewBase64FrameReader returns a FrameReader decoding the frames written by
+// NewBase64FrameWriter. Malformed base64 lines return an error wrapped in a
+// PositionalError carrying the index of the faulty line.
+func NewBase64FrameReader(r io.Reader) FrameReader {
+	lines := NewNewlineDelimitedFrameReader(r, false)
+	index := 0
+	var buf []byte
+	return FrameReaderFunc(func() ([]byte, error) {
+		line, err := lines.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		pos := index
+		index++
+
+		n := base64.StdEncoding.DecodedLen(len(line))
+		if cap(buf) < n {
+			buf = make([]byte, n)
+		}
+		n, err = base64.StdEncoding.Decode(buf[:n], line)
+		if err != nil {
+			return nil, perrors.NewPositionalError(pos, fmt.Errorf("Invalid base64 frame: %w", err))
+		}
+		return buf[:n], nil
+	})
+}
@@ -0,0 +1,51 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	perrors "github.com/optable/optable-pkglib/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBase64Framing(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewBase64FrameWriter(buf)
+	r := NewBase64FrameReader(buf)
+	basicTestFraming(t, w, r)
+}
+
+func TestBase64FramingBinary(t *testing.T) {
+	frames := toFrames("\x00\x01\n\r\xff", "line\nbreak", "text")
+
+	buf := new(bytes.Buffer)
+	w := NewBase64FrameWriter(buf)
+	for _, frame := range frames {
+		_, err := w.Write(frame)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, bytes.Count(buf.Bytes(), []byte("\n")), "payload newlines must be encoded")
+
+	actual, err := ReadAllFrames(NewBase64FrameReader(buf))
+	require.NoError(t, err)
+	assert.Equal(t, frames, actual)
+}
+
+func TestBase64FrameReaderMalformed(t *testing.T) {
+	r := NewBase64FrameReader(bytes.NewBufferString("dmFsaWQ=\nnot base64!"))
+
+	frame, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("valid"), frame)
+
+	_, err = r.Read()
+	var corrupt base64.CorruptInputError
+	assert.ErrorAs(t, err, &corrupt)
+	var posErr *perrors.PositionalError
+	require.ErrorAs(t, err, &posErr)
+	assert.Equal(t, 1, posErr.Position())
+}
@@ -0,0 +1,87 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"errors"
+	"sync"
+)
+
+// ClosedErr is returned when reading a closed FrameReader.
+var ClosedErr = errors.New("Read on closed FrameReader")
+
+type bufferedFrameReader struct {
+	results <-chan readResult
+	done    chan struct{}
+	once    sync.Once
+
+	// The error ending the stream, returned by every following Read.
+	err error
+}
+
+// NewBufferedFrameReader returns a FrameReader reading ahead up to `ahead`
+// frames of `r` in a background goroutine, such that consumers don't stall on
+// slow reads, e.g. network round-trips. Frames and errors are returned in
+// order, the goroutine stops after the first error, including io.EOF. An
+// `ahead` smaller than 1 is treated as 1.
+//
+// The frames are copied since FrameReader may reuse their buffer. The returned
+// FrameReader implements io.Closer to stop reading early, see MaybeClose, the
+// following reads fail with ClosedErr. The goroutine exits once the Read in
+// flight, if any, returns.
+func NewBufferedFrameReader(r FrameReader, ahead int) FrameReader {
+	if ahead < 1 {
+		ahead = 1
+	}
+
+	results := make(chan readResult, ahead)
+	done := make(chan struct{})
+	go func() {
+		defer close(results)
+		for {
+			frame, err := r.Read()
+			if err == nil {
+				frame = append([]byte(nil), frame...)
+			}
+
+			select {
+			case results <- readResult{frame, err}:
+			case <-done:
+				return
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return &bufferedFrameReader{results: results, done: done}
+}
+
+func (b *bufferedFrameReader) Read() ([]byte, error) {
+	select {
+	case <-b.done:
+		return nil, ClosedErr
+	default:
+	}
+
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	select {
+	case res := <-b.results:
+		if res.err != nil {
+			b.err = res.err
+		}
+		return res.frame, res.err
+	case <-b.done:
+		return nil, ClosedErr
+	}
+}
+
+func (b *bufferedFrameReader) Close() error {
+	b.once.Do(func() { close(b.done) })
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingFrameReader yields increasing numbers forever, reusing its buffer.
+type countingFrameReader struct {
+	reads int64
+	buf   []byte
+}
+
+func (c *countingFrameReader) Read() ([]byte, error) {
+	n := atomic.AddInt64(&c.reads, 1)
+	c.buf = strconv.AppendInt(c.buf[:0], n, 10)
+	return c.buf, nil
+}
+
+func TestBufferedFrameReader(t *testing.T) {
+	payload := "a\nb\nc\nd"
+
+	r := NewBufferedFrameReader(NewNewlineDelimitedFrameReader(bytes.NewBufferString(payload), false), 2)
+	defer MaybeClose(r)
+
+	frames, err := ReadAllFrames(r)
+	require.NoError(t, err)
+	assert.Equal(t, toFrames("a", "b", "c", "d"), frames)
+
+	_, err = r.Read()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestBufferedFrameReaderPropagatesErrorsInOrder(t *testing.T) {
+	errRead := errors.New("read failed")
+	r := NewBufferedFrameReader(MultiFrameReader(SliceFrameReader(toFrames("a", "b")), failingFrameReader{errRead}), 8)
+	defer MaybeClose(r)
+
+	for _, expected := range []string{"a", "b"} {
+		frame, err := r.Read()
+		require.NoError(t, err)
+		assert.Equal(t, []byte(expected), frame)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := r.Read()
+		assert.ErrorIs(t, err, errRead)
+	}
+}
+
+func TestBufferedFrameReaderClose(t *testing.T) {
+	source := &countingFrameReader{}
+	r := NewBufferedFrameReader(source, 4)
+
+	// Frames are copied, the source reusing its buffer doesn't alter them.
+	first, err := r.Read()
+	require.NoError(t, err)
+	second, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), first)
+	assert.Equal(t, []byte("2"), second)
+
+	require.NoError(t, MaybeClose(r))
+	require.NoError(t, MaybeClose(r), "Close must be idempotent")
+
+	_, err = r.Read()
+	assert.ErrorIs(t, err, ClosedErr)
+
+	// The goroutine stops reading ahead.
+	time.Sleep(10 * time.Millisecond)
+	reads := atomic.LoadInt64(&source.reads)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, reads, atomic.LoadInt64(&source.reads))
+	assert.LessOrEqual(t, reads, int64(2+4+1))
+}
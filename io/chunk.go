@@ -4,6 +4,7 @@ package io
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"io"
 )
@@ -11,9 +12,21 @@ import (
 type (
 	// ChunkReader breaks a stream into chunks amenable to parallel parsing.
 	ChunkReader interface {
-		// NextChunk returns a FrameReader.
+		// NextChunk returns a FrameReader. The FrameReader may hold resources
+		// and implement io.Closer, in which case the caller must close it once
+		// done, see MaybeClose. ReadAllChunks and ProcessChunks handle this.
 		NextChunk() (FrameReader, error)
 	}
+
+	// OffsetChunkReader is a ChunkReader which also reports where each chunk
+	// starts in the original stream, e.g. to checkpoint the progress of a job
+	// and resume it near where it stopped.
+	OffsetChunkReader interface {
+		ChunkReader
+		// NextChunkAt behaves like NextChunk and also returns the offset, in
+		// bytes, of the first frame of the chunk in the original stream.
+		NextChunkAt() (FrameReader, int64, error)
+	}
 )
 
 var InvalidArgErr = errors.New("Invalid argument")
@@ -22,10 +35,15 @@ var InvalidArgErr = errors.New("Invalid argument")
 // frames delimited by newlines. It is expected that the caller provides a
 // chunkSize large enough to include a full frame. This is a limitation similar
 // to bufio.Scanner. We recommend that the chunkSize should contain a handful
-// of frames. Otherwise use a FrameReader directly.
+// of frames, see RecommendChunkSize. Otherwise use a FrameReader directly.
 //
-// The chunker will not look for `\r` rune like bufio.Scanner (and
-// NewlineDelimitedFrameReader) does.
+// Chunks are split on `\n` only, but the frames of each chunk are read with
+// NewNewlineDelimitedFrameReader which strips a trailing `\r`. Thus `\r\n`
+// delimited streams, e.g. generated on Windows, yield the same frames as with
+// NewNewlineDelimitedFrameReader.
+//
+// The returned ChunkReader implements OffsetChunkReader. Resuming from an
+// offset is done by seeking the stream to it and creating a new ChunkReader.
 func NewNewlineDelimitedChunkReader(reader io.Reader, chunkSize int) (ChunkReader, error) {
 	if chunkSize < 0 {
 		return nil, InvalidArgErr
@@ -42,23 +60,101 @@ func NewNewlineDelimitedChunkReader(reader io.Reader, chunkSize int) (ChunkReade
 	}, nil
 }
 
+const (
+	// recommendSampleSize caps the bytes read by RecommendChunkSize.
+	recommendSampleSize = 1 << 20
+	// recommendFramesPerChunk is the number of average frames held by a
+	// recommended chunk.
+	recommendFramesPerChunk = 64
+	// recommendMaxFrameHeadroom is applied to the largest sampled frame since
+	// the sample may not include the largest frame of the stream.
+	recommendMaxFrameHeadroom = 4
+	// recommendAlignment is the size recommendations are rounded up to.
+	recommendAlignment = 4096
+)
+
+// RecommendChunkSize reads a sample of newline delimited frames, up to 1MiB,
+// and returns a chunkSize for NewNewlineDelimitedChunkReader holding a handful
+// of average frames with ample headroom for the largest sampled frame. It
+// fails with NoFrameFoundErr when the sample holds no frame, e.g. an empty
+// stream or a frame larger than the sample.
+//
+// The sample is consumed, thus it's usually read from a separate reader of the
+// same source, e.g. by seeking the file back afterwards.
+func RecommendChunkSize(sampleReader io.Reader) (int, error) {
+	if sampleReader == nil {
+		return 0, InvalidArgErr
+	}
+
+	buf := make([]byte, recommendSampleSize)
+	n, err := io.ReadFull(sampleReader, buf)
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		// The whole stream was sampled, its last frame may omit the
+		// delimiter.
+		buf = buf[:n]
+	} else if err != nil {
+		return 0, err
+	} else {
+		// Ignore the trailing partial frame.
+		buf = buf[:bytes.LastIndexByte(buf, '\n')+1]
+	}
+
+	sampled, frames, maxFrame := len(buf), 0, 0
+	for len(buf) > 0 {
+		frame := len(buf)
+		if pos := bytes.IndexByte(buf, '\n'); pos != -1 {
+			frame = pos + 1
+		}
+		if frame > maxFrame {
+			maxFrame = frame
+		}
+		frames++
+		buf = buf[frame:]
+	}
+
+	if frames == 0 {
+		return 0, NoFrameFoundErr
+	}
+
+	size := sampled / frames * recommendFramesPerChunk
+	if minSize := maxFrame * recommendMaxFrameHeadroom; size < minSize {
+		size = minSize
+	}
+	return (size + recommendAlignment - 1) / recommendAlignment * recommendAlignment, nil
+}
+
 type delimitedChunker struct {
 	r         io.Reader
 	delimiter byte
 	chunkSize int
 
 	prev []byte
+	// Number of bytes read from r.
+	read int64
 }
 
 var NoFrameFoundErr = errors.New("No frame found in chunk")
 
 func (c *delimitedChunker) NextChunk() (FrameReader, error) {
+	reader, _, err := c.NextChunkAt()
+	return reader, err
+}
+
+func (c *delimitedChunker) NextChunkAt() (FrameReader, int64, error) {
 	if c.r == nil {
-		return nil, io.EOF
+		return nil, 0, io.EOF
+	}
+
+	// The leftover of the previous chunk starts with the delimiter ending the
+	// previous chunk's last frame.
+	offset := c.read
+	if len(c.prev) > 0 {
+		offset -= int64(len(c.prev)) - 1
 	}
 
 	buf := make([]byte, c.chunkSize)
 	n, err := io.ReadFull(c.r, buf)
+	c.read += int64(n)
 	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
 		// ReadFull returns ErrUnexpectedEOF if it couldn't read the full
 		// buffer. We use this signal as equivalent to EOF and only the last
@@ -66,7 +162,7 @@ func (c *delimitedChunker) NextChunk() (FrameReader, error) {
 		c.r = nil
 		buf = buf[:n]
 	} else if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	var buffers []io.Reader
@@ -75,10 +171,13 @@ func (c *delimitedChunker) NextChunk() (FrameReader, error) {
 	}
 
 	pos := bytes.LastIndexByte(buf, c.delimiter)
-	if pos == -1 {
+	if c.r == nil {
+		// The last chunk holds all the remaining frames.
+		buffers = append(buffers, bytes.NewReader(buf))
+	} else if pos == -1 {
 		// We got a chunk and found no frame, that's unexpected
 		if len(buf) == c.chunkSize {
-			return nil, NoFrameFoundErr
+			return nil, 0, NoFrameFoundErr
 		}
 		buffers = append(buffers, bytes.NewReader(buf))
 	} else {
@@ -86,12 +185,146 @@ func (c *delimitedChunker) NextChunk() (FrameReader, error) {
 	}
 
 	reader := io.MultiReader(buffers...)
-	return NewNewlineDelimitedFrameReader(reader, true), nil
+	return &chunkFrameReader{NewNewlineDelimitedFrameReader(reader, true)}, offset, nil
+}
+
+// NewVarLenChunkReader returns a ChunkReader that breaks chunks of frames
+// encoded with NewVarLenFrameWriter. Chunks always hold whole frames, the
+// partial trailing frame of a read is carried over to the next chunk. As with
+// NewNewlineDelimitedChunkReader, the chunkSize must be large enough to
+// include a full frame and should contain a handful of frames.
+//
+// The returned ChunkReader implements OffsetChunkReader.
+func NewVarLenChunkReader(reader io.Reader, chunkSize int) (ChunkReader, error) {
+	if chunkSize <= 0 {
+		return nil, InvalidArgErr
+	}
+
+	if reader == nil {
+		return nil, InvalidArgErr
+	}
+
+	return &varLenChunker{r: reader, chunkSize: chunkSize}, nil
+}
+
+type varLenChunker struct {
+	r         io.Reader
+	chunkSize int
+
+	prev []byte
+	// Number of bytes read from r.
+	read int64
+}
+
+var malformedVarLenErr = errors.New("Malformed varlen frame length")
+
+func (c *varLenChunker) NextChunk() (FrameReader, error) {
+	reader, _, err := c.NextChunkAt()
+	return reader, err
+}
+
+func (c *varLenChunker) NextChunkAt() (FrameReader, int64, error) {
+	if c.r == nil {
+		return nil, 0, io.EOF
+	}
+
+	// The leftover of the previous chunk starts with a frame.
+	offset := c.read - int64(len(c.prev))
+
+	buf := make([]byte, len(c.prev)+c.chunkSize)
+	copy(buf, c.prev)
+	n, err := io.ReadFull(c.r, buf[len(c.prev):])
+	c.read += int64(n)
+	buf, c.prev = buf[:len(c.prev)+n], nil
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		// See delimitedChunker, only the last chunk can be partially filled.
+		c.r = nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	end := len(buf)
+	if c.r != nil {
+		// The last chunk holds all the remaining frames, otherwise the chunk
+		// ends after the last whole frame.
+		if end, err = lastVarLenFrameEnd(buf); err != nil {
+			return nil, 0, err
+		}
+		if end == 0 {
+			return nil, 0, NoFrameFoundErr
+		}
+		c.prev = buf[end:]
+	}
+
+	reader := NewVarLenFrameReader(bytes.NewReader(buf[:end]))
+	return &chunkFrameReader{reader}, offset, nil
+}
+
+// lastVarLenFrameEnd returns the position following the last whole varlen
+// frame of buf.
+func lastVarLenFrameEnd(buf []byte) (int, error) {
+	pos := 0
+	for {
+		payloadLen, n := binary.Uvarint(buf[pos:])
+		if n < 0 {
+			return 0, malformedVarLenErr
+		} else if n == 0 || payloadLen > uint64(len(buf)-pos-n) {
+			// Partial length or partial payload.
+			return pos, nil
+		}
+		pos += n + int(payloadLen)
+	}
+}
+
+// NewFixedSizeChunkReader returns a FrameReader splitting `reader` into raw
+// blocks of `size` bytes, the last block being shorter if needed, e.g. for
+// parallel multipart uploads. Unlike the ChunkReaders, blocks ignore any
+// framing and may split records. Each block is a fresh slice, thus it can be
+// retained, e.g. handed to another goroutine, after the next Read.
+func NewFixedSizeChunkReader(reader io.Reader, size int) (FrameReader, error) {
+	if size <= 0 {
+		return nil, InvalidArgErr
+	}
+
+	if reader == nil {
+		return nil, InvalidArgErr
+	}
+
+	return FrameReaderFunc(func() ([]byte, error) {
+		if reader == nil {
+			return nil, io.EOF
+		}
+
+		buf := make([]byte, size)
+		n, err := io.ReadFull(reader, buf)
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			// See delimitedChunker, only the last block can be short.
+			reader = nil
+			if n == 0 {
+				return nil, io.EOF
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}), nil
+}
+
+// chunkFrameReader is the FrameReader of a chunk. Closing it releases the
+// chunk's buffer.
+type chunkFrameReader struct {
+	FrameReader
+}
+
+func (r *chunkFrameReader) Close() error {
+	r.FrameReader = SliceFrameReader(nil)
+	return nil
 }
 
 // ReadAllChunks consumes all FrameReader from the chunker and returns them in
 // a slice. If an error is encountered (except io.EOF) returns it immediately
-// with a nil slice.
+// with a nil slice, closing the FrameReaders consumed so far. Otherwise the
+// caller owns the FrameReaders and must close them, see MaybeClose.
 //
 // Carefully use this function as it may hold the entire io.Reader in memory and
 // will never return with an infinite stream. This utility function is used
@@ -102,6 +335,9 @@ func ReadAllChunks(chunker ChunkReader) (readers []FrameReader, err error) {
 		if errors.Is(err, io.EOF) {
 			break
 		} else if err != nil {
+			for _, reader := range readers {
+				_ = MaybeClose(reader)
+			}
 			return nil, err
 		}
 
@@ -110,3 +346,27 @@ func ReadAllChunks(chunker ChunkReader) (readers []FrameReader, err error) {
 
 	return readers, nil
 }
+
+// ProcessChunks invokes `process` on every FrameReader of the chunker, in
+// order, and closes each FrameReader once processed (see MaybeClose). It stops
+// at the first error, either of the chunker, of `process` or of closing a
+// FrameReader. Parallel processing is left to `process`, e.g. by reading all
+// frames of the chunk and dispatching them to workers.
+func ProcessChunks(chunker ChunkReader, process func(FrameReader) error) error {
+	for {
+		reader, err := chunker.NextChunk()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		err = process(reader)
+		if closeErr := MaybeClose(reader); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -4,6 +4,9 @@ package io
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -58,3 +61,275 @@ e:90cf2beb42c3ca27328560f1aac067cea6e8bf46d4ab2b4680402c5fb2820e88
 `
 	assertNewLineDelimitedChunker(t, lines)
 }
+
+// closeTrackingChunker yields chunks of a single frame tracking their closure.
+type closeTrackingChunker struct {
+	chunks int
+	closed int
+	err    error
+}
+
+func (c *closeTrackingChunker) NextChunk() (FrameReader, error) {
+	if c.chunks == 0 {
+		if c.err != nil {
+			return nil, c.err
+		}
+		return nil, io.EOF
+	}
+	c.chunks--
+
+	return struct {
+		FrameReader
+		io.Closer
+	}{
+		SliceFrameReader([][]byte{[]byte("frame")}),
+		CloserFn(func() error { c.closed++; return nil }),
+	}, nil
+}
+
+func TestProcessChunksClosesReaders(t *testing.T) {
+	chunker := &closeTrackingChunker{chunks: 3}
+	var frames [][]byte
+	err := ProcessChunks(chunker, func(r FrameReader) error {
+		chunk, err := ReadAllFrames(r)
+		frames = append(frames, chunk...)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Len(t, frames, 3)
+	assert.Equal(t, 3, chunker.closed)
+
+	// Readers are closed even when processing fails.
+	errProcess := errors.New("process failed")
+	chunker = &closeTrackingChunker{chunks: 3}
+	err = ProcessChunks(chunker, func(r FrameReader) error { return errProcess })
+	assert.ErrorIs(t, err, errProcess)
+	assert.Equal(t, 1, chunker.closed)
+}
+
+func TestReadAllChunksClosesReadersOnError(t *testing.T) {
+	errChunk := errors.New("chunk failed")
+	chunker := &closeTrackingChunker{chunks: 2, err: errChunk}
+	readers, err := ReadAllChunks(chunker)
+	assert.ErrorIs(t, err, errChunk)
+	assert.Nil(t, readers)
+	assert.Equal(t, 2, chunker.closed)
+
+	chunker = &closeTrackingChunker{chunks: 2}
+	readers, err = ReadAllChunks(chunker)
+	assert.NoError(t, err)
+	assert.Len(t, readers, 2)
+	assert.Equal(t, 0, chunker.closed)
+}
+
+func TestNewLineDelimitedChunkerReadersAreClosers(t *testing.T) {
+	chunker, err := NewNewlineDelimitedChunkReader(bytes.NewBufferString("a\nb\n"), chunkSize)
+	assert.NoError(t, err)
+
+	reader, err := chunker.NextChunk()
+	assert.NoError(t, err)
+	assert.Implements(t, (*io.Closer)(nil), reader)
+	assert.NoError(t, MaybeClose(reader))
+}
+
+func TestNewLineDelimitedChunkerOffsets(t *testing.T) {
+	payload := "aa\nbbb\ncc\nd\neeee\nf"
+
+	for _, size := range []int{5, 6, 8, 64} {
+		chunker, err := NewNewlineDelimitedChunkReader(bytes.NewBufferString(payload), size)
+		assert.NoError(t, err)
+		offsetChunker, ok := chunker.(OffsetChunkReader)
+		if !assert.True(t, ok) {
+			return
+		}
+
+		var frames [][]byte
+		for {
+			reader, offset, err := offsetChunker.NextChunkAt()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			assert.NoError(t, err)
+
+			chunk, err := ReadAllFrames(reader)
+			assert.NoError(t, err)
+			if len(chunk) == 0 {
+				continue
+			}
+			frames = append(frames, chunk...)
+
+			// Resuming at the offset yields the chunk's frames, and those after.
+			resumed, err := ReadAllFrames(NewNewlineDelimitedFrameReader(bytes.NewBufferString(payload[offset:]), true))
+			assert.NoError(t, err)
+			assert.Equal(t, chunk, resumed[:len(chunk)], "size %d, offset %d", size, offset)
+		}
+
+		assert.Equal(t, toFrames("aa", "bbb", "cc", "d", "eeee", "f"), frames, "size %d", size)
+	}
+}
+
+func TestNewLineDelimitedChunkerCRLF(t *testing.T) {
+	for _, payload := range []string{
+		"aa\r\nbbb\r\ncc\r\nd\r\neeee\r\nf",
+		"aa\r\nbbb\r\ncc\r\nd\r\neeee\r\nf\r\n",
+		"aa\r\nbbb\nc\r\r\n",
+	} {
+		for _, size := range []int{5, 6, 7, 8, 64} {
+			framer := NewNewlineDelimitedFrameReader(bytes.NewBufferString(payload), true)
+			chunker, err := NewNewlineDelimitedChunkReader(bytes.NewBufferString(payload), size)
+			assert.NoError(t, err)
+			assertChunkReaderRoundTrip(t, framer, chunker)
+		}
+	}
+}
+
+func varLenPayload(t *testing.T, frames [][]byte) []byte {
+	buf := new(bytes.Buffer)
+	writer := NewVarLenFrameWriter(buf)
+	for _, frame := range frames {
+		_, err := writer.Write(frame)
+		assert.NoError(t, err)
+	}
+	return buf.Bytes()
+}
+
+func TestVarLenChunker(t *testing.T) {
+	var frames [][]byte
+	for i := 0; i < 50; i++ {
+		frames = append(frames, bytes.Repeat([]byte{byte(i)}, i%20))
+	}
+	payload := varLenPayload(t, frames)
+
+	for _, size := range []int{21, 32, chunkSize, 4096} {
+		framer := NewVarLenFrameReader(bytes.NewReader(payload))
+		chunker, err := NewVarLenChunkReader(bytes.NewReader(payload), size)
+		assert.NoError(t, err)
+		assertChunkReaderRoundTrip(t, framer, chunker)
+	}
+
+	chunker, err := NewVarLenChunkReader(bytes.NewReader(nil), chunkSize)
+	assert.NoError(t, err)
+	readers, err := ReadAllChunks(chunker)
+	assert.NoError(t, err)
+	actual, err := ReadAllFrames(MultiFrameReader(readers...))
+	assert.NoError(t, err)
+	assert.Empty(t, actual)
+}
+
+func TestVarLenChunkerOffsets(t *testing.T) {
+	frames := toFrames("aa", "bbb", "cc", "d", "eeee", "f")
+	payload := varLenPayload(t, frames)
+
+	for _, size := range []int{5, 6, 8, 64} {
+		chunker, err := NewVarLenChunkReader(bytes.NewReader(payload), size)
+		assert.NoError(t, err)
+		offsetChunker := chunker.(OffsetChunkReader)
+
+		var actual [][]byte
+		for {
+			reader, offset, err := offsetChunker.NextChunkAt()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			assert.NoError(t, err)
+
+			chunk, err := ReadAllFrames(reader)
+			assert.NoError(t, err)
+			if len(chunk) == 0 {
+				continue
+			}
+			actual = append(actual, chunk...)
+
+			// Resuming at the offset yields the chunk's frames, and those after.
+			resumed, err := ReadAllFrames(NewVarLenFrameReader(bytes.NewReader(payload[offset:])))
+			assert.NoError(t, err)
+			assert.Equal(t, chunk, resumed[:len(chunk)], "size %d, offset %d", size, offset)
+		}
+
+		assert.Equal(t, frames, actual, "size %d", size)
+	}
+}
+
+func TestVarLenChunkerErrors(t *testing.T) {
+	_, err := NewVarLenChunkReader(bytes.NewReader(nil), 0)
+	assert.ErrorIs(t, err, InvalidArgErr)
+
+	// A frame larger than the chunk.
+	payload := varLenPayload(t, toFrames("aaaaaaaaaa", "b"))
+	chunker, err := NewVarLenChunkReader(bytes.NewReader(payload), 4)
+	assert.NoError(t, err)
+	_, err = chunker.NextChunk()
+	assert.ErrorIs(t, err, NoFrameFoundErr)
+
+	// A length overflowing an uint64.
+	payload = append(bytes.Repeat([]byte{0xff}, 11), 0x01)
+	chunker, err = NewVarLenChunkReader(bytes.NewReader(payload), 11)
+	assert.NoError(t, err)
+	_, err = chunker.NextChunk()
+	assert.Error(t, err)
+}
+
+func TestFixedSizeChunkReader(t *testing.T) {
+	_, err := NewFixedSizeChunkReader(bytes.NewReader(nil), 0)
+	assert.ErrorIs(t, err, InvalidArgErr)
+	_, err = NewFixedSizeChunkReader(nil, 4)
+	assert.ErrorIs(t, err, InvalidArgErr)
+
+	for payload, expected := range map[string][][]byte{
+		"":           nil,
+		"abc":        toFrames("abc"),
+		"abcd":       toFrames("abcd"),
+		"abcd\nefgh": toFrames("abcd", "\nefg", "h"),
+	} {
+		reader, err := NewFixedSizeChunkReader(bytes.NewBufferString(payload), 4)
+		assert.NoError(t, err)
+
+		var blocks [][]byte
+		for {
+			block, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			assert.NoError(t, err)
+			// Retained without copy.
+			blocks = append(blocks, block)
+		}
+		assert.Equal(t, expected, blocks, payload)
+	}
+}
+
+func TestRecommendChunkSize(t *testing.T) {
+	_, err := RecommendChunkSize(nil)
+	assert.ErrorIs(t, err, InvalidArgErr)
+
+	_, err = RecommendChunkSize(bytes.NewReader(nil))
+	assert.ErrorIs(t, err, NoFrameFoundErr)
+	_, err = RecommendChunkSize(strings.NewReader(strings.Repeat("a", recommendSampleSize+1)))
+	assert.ErrorIs(t, err, NoFrameFoundErr, "a frame larger than the sample")
+
+	// Small frames hold a handful of average frames.
+	size, err := RecommendChunkSize(strings.NewReader("a\nbc\nd"))
+	assert.NoError(t, err)
+	assert.Equal(t, recommendAlignment, size)
+
+	// A large frame gets some headroom.
+	payload := strings.Repeat(strings.Repeat("a", 10)+"\n", 100) + strings.Repeat("b", 9999) + "\n"
+	size, err = RecommendChunkSize(strings.NewReader(payload))
+	assert.NoError(t, err)
+	assert.Equal(t, 40960, size)
+
+	// The trailing partial frame of a full sample is ignored.
+	frame := strings.Repeat("a", 99) + "\n"
+	payload = strings.Repeat(frame, recommendSampleSize/len(frame)+1)
+	size, err = RecommendChunkSize(strings.NewReader(payload))
+	assert.NoError(t, err)
+	assert.Equal(t, 8192, size)
+
+	chunker, err := NewNewlineDelimitedChunkReader(strings.NewReader(payload), size)
+	assert.NoError(t, err)
+	readers, err := ReadAllChunks(chunker)
+	assert.NoError(t, err)
+	frames, err := ReadAllFrames(MultiFrameReader(readers...))
+	assert.NoError(t, err)
+	assert.Len(t, frames, recommendSampleSize/len(frame)+1)
+}
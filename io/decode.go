@@ -0,0 +1,52 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"encoding/json"
+	"fmt"
+
+	perrors "github.com/optable/optable-pkglib/errors"
+)
+
+// JSONFrameDecoder decodes successive JSON frames of a FrameReader, e.g.
+// newline-delimited JSON, into typed values.
+type JSONFrameDecoder struct {
+	r     FrameReader
+	index int
+}
+
+// NewJSONFrameDecoder returns a JSONFrameDecoder reading the frames of `r`.
+func NewJSONFrameDecoder(r FrameReader) *JSONFrameDecoder {
+	return &JSONFrameDecoder{r: r}
+}
+
+// Decode unmarshals the next frame into `v`, see json.Unmarshal. Returns
+// io.EOF when no frames are left. Unmarshalling errors are wrapped in a
+// PositionalError carrying the index of the faulty frame, the following
+// frames can still be decoded.
+//
+//	dec := NewJSONFrameDecoder(NewNewlineDelimitedFrameReader(r, true))
+//	for {
+//		var record Record
+//		if err := dec.Decode(&record); errors.Is(err, io.EOF) {
+//			break
+//		} else if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func (d *JSONFrameDecoder) Decode(v interface{}) error {
+	frame, err := d.r.Read()
+	if err != nil {
+		return err
+	}
+
+	pos := d.index
+	d.index++
+
+	if err := json.Unmarshal(frame, v); err != nil {
+		return perrors.NewPositionalError(pos, fmt.Errorf("Failed decoding frame: %w", err))
+	}
+	return nil
+}
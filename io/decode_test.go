@@ -0,0 +1,43 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	perrors "github.com/optable/optable-pkglib/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONFrameDecoder(t *testing.T) {
+	type record struct {
+		ID    int    `json:"id"`
+		Email string `json:"email"`
+	}
+
+	payload := `{"id":1,"email":"a@b.c"}
+{"id":"two"}
+{"id":3}`
+	dec := NewJSONFrameDecoder(NewNewlineDelimitedFrameReader(bytes.NewBufferString(payload), true))
+
+	var r record
+	require.NoError(t, dec.Decode(&r))
+	assert.Equal(t, record{ID: 1, Email: "a@b.c"}, r)
+
+	err := dec.Decode(&record{})
+	var typeErr *json.UnmarshalTypeError
+	assert.ErrorAs(t, err, &typeErr)
+	var posErr *perrors.PositionalError
+	require.ErrorAs(t, err, &posErr)
+	assert.Equal(t, 1, posErr.Position())
+
+	r = record{}
+	require.NoError(t, dec.Decode(&r))
+	assert.Equal(t, record{ID: 3}, r)
+
+	assert.ErrorIs(t, dec.Decode(&r), io.EOF)
+}
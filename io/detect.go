@@ -0,0 +1,108 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FramingKind enumerates the framing formats known by DetectFraming.
+type FramingKind int
+
+const (
+	// FramingUnknown is returned when no format could be recognized.
+	FramingUnknown FramingKind = iota
+	// FramingNewlineDelimited is the format of NewNewlineDelimitedFrameWriter.
+	FramingNewlineDelimited
+	// FramingVarLen is the format of NewVarLenFrameWriter.
+	FramingVarLen
+)
+
+func (k FramingKind) String() string {
+	switch k {
+	case FramingNewlineDelimited:
+		return "newline-delimited"
+	case FramingVarLen:
+		return "varlen"
+	default:
+		return "unknown"
+	}
+}
+
+// Number of bytes inspected by DetectFraming.
+const detectFramingSampleSize = 4096
+
+// DetectFraming guesses the framing format of a stream by peeking at its first
+// bytes. It returns the detected format and a reader that must be used in lieu
+// of `r` since it re-includes the peeked bytes.
+//
+// The heuristics are, in order:
+//
+// - If the sample is valid UTF-8 text without control characters (except
+// `\t`, `\r` and `\n`), the stream is newline-delimited.
+// - If the sample can be walked frame by frame as varlen frames, i.e. every
+// length prefix is followed by a full payload or the end of the sample, the
+// stream is varlen framed.
+// - Otherwise, or if the stream is empty, the format is unknown.
+//
+// Heuristics are not proofs. A varlen stream of text payloads whose lengths
+// happen to be printable characters is reported as newline-delimited, and any
+// binary sample that happens to be walkable is reported as varlen.
+func DetectFraming(r io.Reader) (FramingKind, io.Reader, error) {
+	buf := bufio.NewReaderSize(r, detectFramingSampleSize)
+	sample, err := buf.Peek(detectFramingSampleSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return FramingUnknown, buf, err
+	}
+
+	switch {
+	case len(sample) == 0:
+		return FramingUnknown, buf, nil
+	case isText(sample, len(sample) == detectFramingSampleSize):
+		return FramingNewlineDelimited, buf, nil
+	case isVarLen(sample):
+		return FramingVarLen, buf, nil
+	default:
+		return FramingUnknown, buf, nil
+	}
+}
+
+func isText(sample []byte, truncated bool) bool {
+	for len(sample) > 0 {
+		c, size := utf8.DecodeRune(sample)
+		if c == utf8.RuneError && size <= 1 {
+			// A truncated sample may cut the last rune in half.
+			return truncated && !utf8.FullRune(sample)
+		}
+		if unicode.IsControl(c) && c != '\t' && c != '\r' && c != '\n' {
+			return false
+		}
+		sample = sample[size:]
+	}
+	return true
+}
+
+func isVarLen(sample []byte) bool {
+	for len(sample) > 0 {
+		payloadLen, n := binary.Uvarint(sample)
+		if n == 0 {
+			// The sample ends within the length prefix.
+			return true
+		} else if n < 0 {
+			return false
+		}
+
+		sample = sample[n:]
+		if payloadLen >= uint64(len(sample)) {
+			// The sample ends within (or exactly at the end of) the payload.
+			return true
+		}
+		sample = sample[payloadLen:]
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func assertDetectFraming(t *testing.T, expected FramingKind, payload []byte) {
+	kind, r, err := DetectFraming(bytes.NewReader(payload))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, kind, "expected %s, got %s", expected, kind)
+
+	// No bytes are lost by the detection.
+	actual, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, actual)
+}
+
+func TestDetectFraming(t *testing.T) {
+	newline := new(bytes.Buffer)
+	varlen := new(bytes.Buffer)
+	nw, vw := NewNewlineDelimitedFrameWriter(newline), NewVarLenFrameWriter(varlen)
+	for i := 0; i < 1000; i++ {
+		payload := []byte{byte(i), byte(i >> 8), 0, 'e', ':', 'x'}
+		_, err := vw.Write(payload)
+		assert.NoError(t, err)
+		_, err = nw.Write([]byte("e:538c7f96b164bf1b97bb9f4bb472e89f5b1484f25209c9d9343e92ba09dd9d52"))
+		assert.NoError(t, err)
+	}
+
+	assertDetectFraming(t, FramingNewlineDelimited, newline.Bytes())
+	assertDetectFraming(t, FramingVarLen, varlen.Bytes())
+	assertDetectFraming(t, FramingUnknown, []byte{})
+	assertDetectFraming(t, FramingUnknown, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+}
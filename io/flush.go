@@ -0,0 +1,98 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Flusher is implemented by buffered writers, e.g. bufio.Writer.
+type Flusher interface {
+	Flush() error
+}
+
+// FlushableFrameWriter is a FrameWriter buffering its frames. Flush forces the
+// buffered frames out, e.g. at the end of a batch, without closing.
+type FlushableFrameWriter interface {
+	FrameWriter
+	Flusher
+}
+
+type bufferedFrameWriter struct {
+	FrameWriter
+	buf *bufio.Writer
+}
+
+func (b *bufferedFrameWriter) Flush() error {
+	return b.buf.Flush()
+}
+
+// NewBufferedFrameWriter buffers the frames written to `w` in a bufio.Writer
+// of `size` bytes, the frames are framed by `framing`, e.g.
+// NewVarLenFrameWriter. A negative size uses the default buffer size. The
+// caller must Flush the returned FrameWriter once done.
+func NewBufferedFrameWriter(w io.Writer, size int, framing func(io.Writer) FrameWriter) FlushableFrameWriter {
+	if size < 0 {
+		size = defaultBufSize
+	}
+	buf := bufio.NewWriterSize(w, size)
+	return &bufferedFrameWriter{FrameWriter: framing(buf), buf: buf}
+}
+
+// FlushFrameWriter flushes if the passed FrameWriter implements Flusher and
+// does nothing otherwise, similarly to MaybeClose.
+func FlushFrameWriter(w FrameWriter) error {
+	if f, ok := w.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// NewPeriodicFlushFrameWriter wraps a FrameWriter such that `flushable` is
+// flushed every `interval`, in addition to any size-based flushing done by the
+// buffer. This bounds the latency of frames in near-real-time pipelines. The
+// flushing goroutine stops when the context is cancelled.
+//
+// Writes and flushes are mutually excluded, thus the returned FrameWriter is
+// safe to use concurrently. If a periodic flush fails, the error is returned by
+// the next Write.
+func NewPeriodicFlushFrameWriter(ctx context.Context, w FrameWriter, flushable Flusher, interval time.Duration) FrameWriter {
+	var (
+		mu       sync.Mutex
+		flushErr error
+	)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				mu.Lock()
+				if err := flushable.Flush(); err != nil && flushErr == nil {
+					flushErr = err
+				}
+				mu.Unlock()
+			}
+		}
+	}()
+
+	return FrameWriterFunc(func(payload []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err := flushErr; err != nil {
+			flushErr = nil
+			return 0, err
+		}
+
+		return w.Write(payload)
+	})
+}
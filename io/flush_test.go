@@ -0,0 +1,68 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestPeriodicFlushFrameWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out := new(syncBuffer)
+	buf := bufio.NewWriterSize(out, 4096)
+	w := NewPeriodicFlushFrameWriter(ctx, NewNewlineDelimitedFrameWriter(buf), buf, 10*time.Millisecond)
+
+	_, err := w.Write([]byte("hello"))
+	assert.NoError(t, err)
+
+	// The payload is smaller than the buffer, only a periodic flush can push it.
+	assert.Eventually(t, func() bool {
+		return out.String() == "hello"
+	}, time.Second, time.Millisecond)
+}
+
+func TestBufferedFrameWriter(t *testing.T) {
+	out := new(bytes.Buffer)
+	w := NewBufferedFrameWriter(out, 4096, NewVarLenFrameWriter)
+
+	for _, frame := range toFrames("a", "bc", "def") {
+		_, err := w.Write(frame)
+		assert.NoError(t, err)
+	}
+	assert.Zero(t, out.Len(), "frames should be buffered")
+
+	assert.NoError(t, FlushFrameWriter(w))
+	frames, err := ReadAllFrames(NewVarLenFrameReader(out))
+	assert.NoError(t, err)
+	assert.Equal(t, toFrames("a", "bc", "def"), frames)
+
+	// No-op on FrameWriter not implementing Flusher.
+	assert.NoError(t, FlushFrameWriter(NewVarLenFrameWriter(out)))
+}
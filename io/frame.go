@@ -4,11 +4,15 @@ package io
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"strconv"
 	"sync"
 
+	perrors "github.com/optable/optable-pkglib/errors"
 	"github.com/optable/optable-pkglib/unit"
 )
 
@@ -33,22 +37,48 @@ type FrameReader interface {
 	Read() ([]byte, error)
 }
 
+// ResettableFrameWriter is a FrameWriter which can be reset to write to
+// another io.Writer, discarding its state. This allows reusing FrameWriters,
+// e.g. with a sync.Pool. The FrameWriters returned by NewVarLenFrameWriter,
+// NewNewlineDelimitedFrameWriter and NewBase64FrameWriter implement it, the
+// decorators of this package, e.g. ConcurrentFrameWriter, don't.
+type ResettableFrameWriter interface {
+	FrameWriter
+	Reset(w io.Writer)
+}
+
+var (
+	_ ResettableFrameWriter = (*varLenFrameWriter)(nil)
+	_ ResettableFrameWriter = (*newlineDelimitedFrameWriter)(nil)
+	_ ResettableFrameWriter = (*base64FrameWriter)(nil)
+)
+
+type varLenFrameWriter struct {
+	w io.Writer
+	// Buffer used to store the varlen payload.
+	buf [binary.MaxVarintLen64]byte
+}
+
 // NewVarLenWriter creates a FrameWriter where each frame is composed of the
 // size (uint64) of the message encoded with varlen encoding followed by the
 // message itself.
 func NewVarLenFrameWriter(w io.Writer) FrameWriter {
-	// Buffer used to store the varlen payload.
-	var buf [binary.MaxVarintLen32]byte
-	return frameWriterFn(func(payload []byte) (int, error) {
-		encodedLength := binary.PutUvarint(buf[:], uint64(len(payload)))
-		sync, err := w.Write(buf[:encodedLength])
-		if err != nil {
-			return sync, err
-		}
+	return &varLenFrameWriter{w: w}
+}
 
-		n, err := w.Write(payload)
-		return n + sync, err
-	})
+func (v *varLenFrameWriter) Write(payload []byte) (int, error) {
+	encodedLength := binary.PutUvarint(v.buf[:], uint64(len(payload)))
+	sync, err := v.w.Write(v.buf[:encodedLength])
+	if err != nil {
+		return sync, err
+	}
+
+	n, err := v.w.Write(payload)
+	return n + sync, err
+}
+
+func (v *varLenFrameWriter) Reset(w io.Writer) {
+	v.w = w
 }
 
 const varlenFrameReaderBufferSize = 256
@@ -59,7 +89,7 @@ func NewVarLenFrameReader(r io.Reader) FrameReader {
 	// ReadVarint requires a ReadByte method.
 	bufReader := bufio.NewReader(r)
 	buf := make([]byte, varlenFrameReaderBufferSize)
-	return frameReaderFn(func() ([]byte, error) {
+	return FrameReaderFunc(func() ([]byte, error) {
 		payloadLen, err := binary.ReadUvarint(bufReader)
 		if err != nil {
 			// If io.EOF is returned, there's no more frame and we're ok.
@@ -89,26 +119,74 @@ func NewVarLenFrameReader(r io.Reader) FrameReader {
 // caller.
 //
 // This framing is not robust due to the previous limitation but is provided
-// for the ubiquitous json-newline-delimited format.
-func NewNewlineDelimitedFrameWriter(w io.Writer) FrameWriter {
-	first := true
-	newline := []byte{'\n'}
-	return frameWriterFn(func(payload []byte) (int, error) {
-		if first {
-			first = false
-			return w.Write(payload)
-		}
+// for the ubiquitous json-newline-delimited format. See
+// WithNewlineValidation to enforce it.
+func NewNewlineDelimitedFrameWriter(w io.Writer, opts ...NewlineDelimitedOption) FrameWriter {
+	writer := &newlineDelimitedFrameWriter{w: w, first: true}
+	for _, opt := range opts {
+		opt.apply(writer)
+	}
+	return writer
+}
 
-		written, err := w.Write(newline)
-		if err != nil {
-			return written, err
-		}
+type (
+	// NewlineDelimitedOption customizes NewNewlineDelimitedFrameWriter.
+	NewlineDelimitedOption interface {
+		apply(w *newlineDelimitedFrameWriter)
+	}
 
-		n, err := w.Write(payload)
-		return n + written, err
+	newlineDelimitedOptionFn func(w *newlineDelimitedFrameWriter)
+)
+
+func (fn newlineDelimitedOptionFn) apply(w *newlineDelimitedFrameWriter) {
+	fn(w)
+}
+
+// EmbeddedNewlineErr is returned by the FrameWriter of
+// NewNewlineDelimitedFrameWriter, with WithNewlineValidation, when a payload
+// contains a newline.
+var EmbeddedNewlineErr = errors.New("Payload contains a newline")
+
+// WithNewlineValidation fails the writes of payloads containing a `\n` or a
+// `\r` with EmbeddedNewlineErr, nothing is written. Otherwise such payloads
+// are silently split into multiple frames, or altered, when read back.
+func WithNewlineValidation() NewlineDelimitedOption {
+	return newlineDelimitedOptionFn(func(w *newlineDelimitedFrameWriter) {
+		w.validate = true
 	})
 }
 
+type newlineDelimitedFrameWriter struct {
+	w        io.Writer
+	first    bool
+	validate bool
+}
+
+var newline = []byte{'\n'}
+
+func (l *newlineDelimitedFrameWriter) Write(payload []byte) (int, error) {
+	if l.validate && bytes.ContainsAny(payload, "\r\n") {
+		return 0, EmbeddedNewlineErr
+	}
+
+	if l.first {
+		l.first = false
+		return l.w.Write(payload)
+	}
+
+	written, err := l.w.Write(newline)
+	if err != nil {
+		return written, err
+	}
+
+	n, err := l.w.Write(payload)
+	return n + written, err
+}
+
+func (l *newlineDelimitedFrameWriter) Reset(w io.Writer) {
+	l.w, l.first = w, true
+}
+
 // NewNewlineDelimitedReader parses stream separated by newlines. The
 // implementation uses bufio.Scanner underneath which has some noted
 // peculiarity:
@@ -126,7 +204,7 @@ func NewNewlineDelimitedFrameReader(r io.Reader, skipEmpty bool) FrameReader {
 	buf := make([]byte, unit.Mebibyte)
 	scanner.Buffer(buf, 0)
 
-	return frameReaderFn(func() ([]byte, error) {
+	return FrameReaderFunc(func() ([]byte, error) {
 		for {
 			if !scanner.Scan() {
 				err := scanner.Err()
@@ -145,6 +223,46 @@ func NewNewlineDelimitedFrameReader(r io.Reader, skipEmpty bool) FrameReader {
 	})
 }
 
+// LengthMismatchErr is returned when the length announced by a frame doesn't
+// match its payload.
+var LengthMismatchErr = errors.New("Frame length mismatch")
+
+// NewLengthPrefixedNewlineFrameReader parses newline-delimited records where
+// each record starts with the ASCII decimal length of its payload followed by
+// a single space, e.g. `5 hello`. The length is validated and stripped, only
+// the payload is returned. Errors are wrapped in a PositionalError carrying the
+// index of the faulty frame.
+func NewLengthPrefixedNewlineFrameReader(r io.Reader) FrameReader {
+	lines := NewNewlineDelimitedFrameReader(r, false)
+	index := 0
+	return FrameReaderFunc(func() ([]byte, error) {
+		line, err := lines.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		pos := index
+		index++
+
+		sep := bytes.IndexByte(line, ' ')
+		if sep == -1 {
+			return nil, perrors.NewPositionalError(pos, errors.New("Missing frame length"))
+		}
+
+		length, err := strconv.ParseUint(string(line[:sep]), 10, 64)
+		if err != nil {
+			return nil, perrors.NewPositionalError(pos, fmt.Errorf("Invalid frame length: %w", err))
+		}
+
+		payload := line[sep+1:]
+		if uint64(len(payload)) != length {
+			return nil, perrors.NewPositionalError(pos, fmt.Errorf("%w: expected %d, got %d", LengthMismatchErr, length, len(payload)))
+		}
+
+		return payload, nil
+	})
+}
+
 type multiFrameReader struct {
 	readers []FrameReader
 }
@@ -174,6 +292,27 @@ func MultiFrameReader(readers ...FrameReader) FrameReader {
 	return &multiFrameReader{r}
 }
 
+// MultiFrameWriter returns a FrameWriter duplicating its writes to all the
+// FrameWriters, e.g. a local archive and a remote sink. This is similar to
+// io.MultiWriter. Each write is forwarded to the FrameWriters in order, the
+// first error stops the write and is returned. The returned count is the sum
+// of the bytes written by the FrameWriters.
+func MultiFrameWriter(writers ...FrameWriter) FrameWriter {
+	w := make([]FrameWriter, len(writers))
+	copy(w, writers)
+	return FrameWriterFunc(func(payload []byte) (int, error) {
+		var written int
+		for _, writer := range w {
+			n, err := writer.Write(payload)
+			written += n
+			if err != nil {
+				return written, err
+			}
+		}
+		return written, nil
+	})
+}
+
 // ReadAllFrames returns all frame exposed by a FrameReader until io.EOF is
 // reached. If an error is encountered, it returns said error with an empty slice.
 func ReadAllFrames(r FrameReader) ([][]byte, error) {
@@ -192,6 +331,38 @@ func ReadAllFrames(r FrameReader) ([][]byte, error) {
 	}
 }
 
+// ReadAllFramesLenient behaves like ReadAllFrames but skips the frames
+// failing to be read instead of aborting, e.g. the malformed lines of
+// NewBase64FrameReader. It returns the frames read successfully along with
+// the errors, aggregated with errors.Append, or nil.
+//
+// Only the frame-level errors, i.e. wrapping an errors.PositionalError, are
+// skipped. Reading stops at io.EOF or at any other error since the FrameReader
+// likely can't make progress, e.g. a failing io.Reader.
+func ReadAllFramesLenient(r FrameReader) ([][]byte, error) {
+	var (
+		frames = make([][]byte, 0, 16)
+		errs   error
+		posErr *perrors.PositionalError
+	)
+	for {
+		frame, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return frames, errs
+		} else if err != nil {
+			errs = perrors.Append(errs, err)
+			if !errors.As(err, &posErr) {
+				return frames, errs
+			}
+			continue
+		}
+
+		newFrame := make([]byte, len(frame))
+		copy(newFrame, frame)
+		frames = append(frames, newFrame)
+	}
+}
+
 type sliceFrameReader struct {
 	frames [][]byte
 	pos    int
@@ -213,24 +384,76 @@ func SliceFrameReader(frames [][]byte) FrameReader {
 	return &sliceFrameReader{frames: frames}
 }
 
+// DiscardFrameWriter returns a FrameWriter on which all writes succeed
+// without doing anything, similarly to io.Discard. It's handy for
+// benchmarking producers in isolation.
+func DiscardFrameWriter() FrameWriter {
+	return FrameWriterFunc(func(payload []byte) (int, error) {
+		return len(payload), nil
+	})
+}
+
+type repeatFrameReader struct {
+	payload []byte
+	left    int
+}
+
+func (r *repeatFrameReader) Read() ([]byte, error) {
+	if r.left <= 0 {
+		return nil, io.EOF
+	}
+
+	r.left--
+	return r.payload, nil
+}
+
+// RepeatFrameReader returns a FrameReader yielding payload n times before
+// io.EOF, e.g. a nil payload for empty frames. The same slice is returned
+// for every frame, consumers must not modify it.
+func RepeatFrameReader(payload []byte, n int) FrameReader {
+	return &repeatFrameReader{payload: payload, left: n}
+}
+
 // ConcurrentFrameWriter protects a FrameWriter with a mutex.
 func ConcurrentFrameWriter(w FrameWriter) FrameWriter {
 	var mu sync.Mutex
-	return frameWriterFn(func(payload []byte) (int, error) {
+	return FrameWriterFunc(func(payload []byte) (int, error) {
 		mu.Lock()
 		defer mu.Unlock()
 		return w.Write(payload)
 	})
 }
 
-type frameWriterFn func([]byte) (int, error)
+// ConcurrentFrameReader protects a FrameReader with a mutex, e.g. to share it
+// between the workers of a pool. The frames are copied since FrameReader may
+// reuse their buffer, e.g. NewVarLenFrameReader, which would race between
+// consumers.
+func ConcurrentFrameReader(r FrameReader) FrameReader {
+	var mu sync.Mutex
+	return FrameReaderFunc(func() ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		frame, err := r.Read()
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), frame...), nil
+	})
+}
+
+// FrameWriterFunc adapts a function to a FrameWriter, similarly to
+// http.HandlerFunc.
+type FrameWriterFunc func([]byte) (int, error)
 
-func (f frameWriterFn) Write(payload []byte) (int, error) {
+func (f FrameWriterFunc) Write(payload []byte) (int, error) {
 	return f(payload)
 }
 
-type frameReaderFn func() ([]byte, error)
+// FrameReaderFunc adapts a function to a FrameReader, similarly to
+// http.HandlerFunc.
+type FrameReaderFunc func() ([]byte, error)
 
-func (f frameReaderFn) Read() ([]byte, error) {
+func (f FrameReaderFunc) Read() ([]byte, error) {
 	return f()
 }
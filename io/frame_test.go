@@ -4,8 +4,16 @@ package io
 
 import (
 	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
 	"testing"
 
+	perrors "github.com/optable/optable-pkglib/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -39,3 +47,239 @@ func TestNewlineDelimitedFraming(t *testing.T) {
 	r := NewNewlineDelimitedFrameReader(buf, skipEmpty)
 	basicTestFraming(t, w, r)
 }
+
+func TestNewlineDelimitedFrameWriterValidation(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewNewlineDelimitedFrameWriter(buf, WithNewlineValidation())
+
+	for _, payload := range []string{"a\nb", "a\r", "\n"} {
+		n, err := w.Write([]byte(payload))
+		assert.ErrorIs(t, err, EmbeddedNewlineErr, payload)
+		assert.Equal(t, 0, n)
+	}
+	_, err := w.Write([]byte("a"))
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("b\nc"))
+	assert.ErrorIs(t, err, EmbeddedNewlineErr)
+	_, err = w.Write([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a\nb", buf.String(), "invalid payloads are not written")
+
+	// Without validation, the payload is silently split.
+	buf.Reset()
+	_, err = NewNewlineDelimitedFrameWriter(buf).Write([]byte("a\nb"))
+	assert.NoError(t, err)
+	frames, err := ReadAllFrames(NewNewlineDelimitedFrameReader(buf, false))
+	assert.NoError(t, err)
+	assert.Equal(t, toFrames("a", "b"), frames)
+}
+
+func TestLengthPrefixedNewlineFrameReader(t *testing.T) {
+	r := NewLengthPrefixedNewlineFrameReader(bytes.NewBufferString("5 hello\n0 \n11 hello world\n"))
+	frames, err := ReadAllFrames(r)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("hello"), {}, []byte("hello world")}, frames)
+
+	r = NewLengthPrefixedNewlineFrameReader(bytes.NewBufferString("5 hello\n4 world\n"))
+	frame, err := r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), frame)
+
+	_, err = r.Read()
+	assert.ErrorIs(t, err, LengthMismatchErr)
+	var posErr *perrors.PositionalError
+	if assert.True(t, errors.As(err, &posErr)) {
+		assert.Equal(t, 1, posErr.Position())
+	}
+
+	_, err = r.Read()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestConcurrentFrameReader(t *testing.T) {
+	const frames, workers = 1000, 8
+
+	buf := new(bytes.Buffer)
+	w := NewVarLenFrameWriter(buf)
+	var expected []string
+	for i := 0; i < frames; i++ {
+		frame := strconv.Itoa(i)
+		_, err := w.Write([]byte(frame))
+		assert.NoError(t, err)
+		expected = append(expected, frame)
+	}
+
+	r := ConcurrentFrameReader(NewVarLenFrameReader(buf))
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		actual []string
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var read [][]byte
+			for {
+				frame, err := r.Read()
+				if err != nil {
+					assert.ErrorIs(t, err, io.EOF)
+					break
+				}
+				read = append(read, frame)
+			}
+
+			// Frames are inspected once the stream is exhausted, a shared buffer
+			// would have been overwritten by then.
+			mu.Lock()
+			defer mu.Unlock()
+			for _, frame := range read {
+				actual = append(actual, string(frame))
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Strings(expected)
+	sort.Strings(actual)
+	assert.Equal(t, expected, actual)
+}
+
+func TestResettableFrameWriters(t *testing.T) {
+	for name, tc := range map[string]struct {
+		writer func(io.Writer) FrameWriter
+		reader func(io.Reader) FrameReader
+	}{
+		"varlen":  {writer: NewVarLenFrameWriter, reader: NewVarLenFrameReader},
+		"newline": {writer: func(w io.Writer) FrameWriter { return NewNewlineDelimitedFrameWriter(w) }, reader: func(r io.Reader) FrameReader { return NewNewlineDelimitedFrameReader(r, false) }},
+		"base64":  {writer: NewBase64FrameWriter, reader: NewBase64FrameReader},
+	} {
+		t.Run(name, func(t *testing.T) {
+			pool := sync.Pool{New: func() interface{} { return tc.writer(nil) }}
+
+			for i := 0; i < 3; i++ {
+				buf := new(bytes.Buffer)
+				w := pool.Get().(ResettableFrameWriter)
+				w.Reset(buf)
+				for _, frame := range []string{"a", "bc"} {
+					_, err := w.Write([]byte(frame))
+					assert.NoError(t, err)
+				}
+				pool.Put(w)
+
+				frames, err := ReadAllFrames(tc.reader(buf))
+				assert.NoError(t, err)
+				assert.Equal(t, [][]byte{[]byte("a"), []byte("bc")}, frames)
+			}
+		})
+	}
+}
+
+func TestMultiFrameWriter(t *testing.T) {
+	varlen, newline := new(bytes.Buffer), new(bytes.Buffer)
+	w := MultiFrameWriter(NewVarLenFrameWriter(varlen), NewNewlineDelimitedFrameWriter(newline))
+
+	for _, frame := range []string{"a", "bc"} {
+		before := varlen.Len() + newline.Len()
+		n, err := w.Write([]byte(frame))
+		assert.NoError(t, err)
+		assert.Equal(t, varlen.Len()+newline.Len()-before, n)
+	}
+
+	frames, err := ReadAllFrames(NewVarLenFrameReader(varlen))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("bc")}, frames)
+	assert.Equal(t, "a\nbc", newline.String())
+
+	errWrite := errors.New("write failed")
+	failing := FrameWriterFunc(func([]byte) (int, error) { return 0, errWrite })
+	last := new(bytes.Buffer)
+	_, err = MultiFrameWriter(failing, NewNewlineDelimitedFrameWriter(last)).Write([]byte("a"))
+	assert.ErrorIs(t, err, errWrite)
+	assert.Zero(t, last.Len(), "writes stop at the first error")
+}
+
+func TestFrameFuncs(t *testing.T) {
+	var written [][]byte
+	var w FrameWriter = FrameWriterFunc(func(payload []byte) (int, error) {
+		written = append(written, payload)
+		return len(payload), nil
+	})
+	n, err := w.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	var r FrameReader = FrameReaderFunc(func() ([]byte, error) {
+		if len(written) == 0 {
+			return nil, io.EOF
+		}
+		frame := written[0]
+		written = written[1:]
+		return frame, nil
+	})
+	frames, err := ReadAllFrames(r)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("abc")}, frames)
+}
+
+func TestDiscardAndRepeatFrames(t *testing.T) {
+	n, err := DiscardFrameWriter().Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	frames, err := ReadAllFrames(RepeatFrameReader([]byte("a"), 3))
+	assert.NoError(t, err)
+	assert.Equal(t, toFrames("a", "a", "a"), frames)
+
+	frames, err = ReadAllFrames(RepeatFrameReader(nil, 2))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{{}, {}}, frames)
+
+	_, err = RepeatFrameReader([]byte("a"), 0).Read()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReadAllFramesLenient(t *testing.T) {
+	payload := base64.StdEncoding.EncodeToString([]byte("a")) + "\n!\n" +
+		base64.StdEncoding.EncodeToString([]byte("b")) + "\n?\n"
+	frames, err := ReadAllFramesLenient(NewBase64FrameReader(bytes.NewBufferString(payload)))
+	assert.Equal(t, toFrames("a", "b"), frames)
+	assert.Equal(t, []int{1, 3}, perrors.PositionsOf(err))
+
+	frames, err = ReadAllFramesLenient(SliceFrameReader(toFrames("a")))
+	assert.NoError(t, err)
+	assert.Equal(t, toFrames("a"), frames)
+
+	// Other errors stop the reading.
+	errRead := errors.New("read failed")
+	frames, err = ReadAllFramesLenient(MultiFrameReader(SliceFrameReader(toFrames("a")), failingFrameReader{errRead}))
+	assert.ErrorIs(t, err, errRead)
+	assert.Equal(t, toFrames("a"), frames)
+
+	// Even when each error is a new value.
+	failures := 0
+	failing := readerFunc(func([]byte) (int, error) {
+		failures++
+		return 0, fmt.Errorf("read failed %d", failures)
+	})
+	frames, err = ReadAllFramesLenient(NewBase64FrameReader(io.MultiReader(bytes.NewBufferString(payload), failing)))
+	assert.Equal(t, toFrames("a", "b"), frames)
+	assert.Equal(t, []int{1, 3}, perrors.PositionsOf(err))
+	assert.EqualError(t, err.(*perrors.Errors).Errors()[2], "read failed 1")
+	assert.Equal(t, 1, failures)
+
+	failures = 0
+	frames, err = ReadAllFramesLenient(FrameReaderFunc(func() ([]byte, error) {
+		failures++
+		return nil, fmt.Errorf("read failed %d", failures)
+	}))
+	assert.Empty(t, frames)
+	assert.EqualError(t, err, "read failed 1")
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
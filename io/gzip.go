@@ -0,0 +1,28 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// NewGzipFrameReader returns the FrameReader built by `inner` over the
+// decompressed stream of `r`, e.g. a gzipped newline delimited file:
+//
+//	reader, closer, err := NewGzipFrameReader(file, func(r io.Reader) FrameReader {
+//		return NewNewlineDelimitedFrameReader(r, true)
+//	})
+//
+// The returned io.Closer releases the gzip.Reader once done reading, it
+// doesn't close `r`. Multistream files, i.e. concatenated gzip members, are
+// read as a single stream. An error is returned if `r` doesn't start with a
+// valid gzip header, corrupted data is reported by the FrameReader.
+func NewGzipFrameReader(r io.Reader, inner func(io.Reader) FrameReader) (FrameReader, io.Closer, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed reading gzip header: %w", err)
+	}
+	return inner(gz), gz, nil
+}
@@ -0,0 +1,35 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGzipFrameReader(t *testing.T) {
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	w := NewNewlineDelimitedFrameWriter(gz)
+	for _, frame := range toFrames("a", "bb", "ccc") {
+		_, err := w.Write(frame)
+		require.NoError(t, err)
+	}
+	require.NoError(t, gz.Close())
+
+	lines := func(r io.Reader) FrameReader { return NewNewlineDelimitedFrameReader(r, true) }
+	reader, closer, err := NewGzipFrameReader(buf, lines)
+	require.NoError(t, err)
+	frames, err := ReadAllFrames(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, toFrames("a", "bb", "ccc"), frames)
+	assert.NoError(t, closer.Close())
+
+	_, _, err = NewGzipFrameReader(bytes.NewBufferString("not gzipped"), lines)
+	assert.ErrorIs(t, err, gzip.ErrHeader)
+}
@@ -0,0 +1,59 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"time"
+)
+
+type readResult struct {
+	frame []byte
+	err   error
+}
+
+type heartbeatFrameReader struct {
+	r         FrameReader
+	interval  time.Duration
+	heartbeat []byte
+
+	// Non-nil when a Read on the underlying FrameReader is in flight.
+	pending chan readResult
+}
+
+// NewHeartbeatFrameReader returns a FrameReader that emits the `heartbeat`
+// frame whenever the underlying FrameReader doesn't yield a frame within
+// `interval`. This is useful to keep alive long-lived streams when the source
+// is idle. Real frames are resumed as soon as they are available, and no frame
+// of the underlying FrameReader is ever dropped.
+//
+// The returned FrameReader returns the `heartbeat` slice as is. Callers
+// distinguish heartbeats by choosing a payload that the source never produces,
+// e.g. an empty frame when the source skips empty frames, and comparing it with
+// bytes.Equal.
+//
+// Each underlying Read is done in a goroutine which outlives the call to Read
+// when a heartbeat is emitted. Its result is returned by the next Read.
+func NewHeartbeatFrameReader(r FrameReader, interval time.Duration, heartbeat []byte) FrameReader {
+	return &heartbeatFrameReader{r: r, interval: interval, heartbeat: heartbeat}
+}
+
+func (h *heartbeatFrameReader) Read() ([]byte, error) {
+	if h.pending == nil {
+		h.pending = make(chan readResult, 1)
+		go func(results chan<- readResult) {
+			frame, err := h.r.Read()
+			results <- readResult{frame, err}
+		}(h.pending)
+	}
+
+	timer := time.NewTimer(h.interval)
+	defer timer.Stop()
+
+	select {
+	case res := <-h.pending:
+		h.pending = nil
+		return res.frame, res.err
+	case <-timer.C:
+		return h.heartbeat, nil
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var heartbeat = []byte("heartbeat")
+
+// chanFrameReader yields the frames sent on the channel until it is closed.
+type chanFrameReader chan []byte
+
+func (c chanFrameReader) Read() ([]byte, error) {
+	frame, ok := <-c
+	if !ok {
+		return nil, io.EOF
+	}
+	return frame, nil
+}
+
+func TestHeartbeatFrameReaderIdle(t *testing.T) {
+	source := make(chanFrameReader)
+	r := NewHeartbeatFrameReader(source, time.Millisecond, heartbeat)
+
+	for i := 0; i < 3; i++ {
+		frame, err := r.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, heartbeat, frame)
+	}
+
+	// Real frames are resumed once available.
+	go func() {
+		source <- []byte("hello")
+		close(source)
+	}()
+
+	var frames [][]byte
+	for {
+		frame, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		frames = append(frames, frame)
+	}
+	assert.Contains(t, frames, []byte("hello"))
+}
+
+func TestHeartbeatFrameReaderActive(t *testing.T) {
+	frames := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	r := NewHeartbeatFrameReader(SliceFrameReader(frames), time.Minute, heartbeat)
+
+	actual, err := ReadAllFrames(r)
+	assert.NoError(t, err)
+	assert.Equal(t, frames, actual)
+}
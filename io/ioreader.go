@@ -0,0 +1,53 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"io"
+)
+
+type frameIOReader struct {
+	r   FrameReader
+	sep []byte
+
+	// The current frame prefixed by the separator, and its bytes not yet read.
+	buf     []byte
+	pending []byte
+	started bool
+	err     error
+}
+
+// NewFrameReaderIOReader returns an io.Reader streaming the payloads of the
+// frames of `r`, with `sep` inserted between frames. This is the inverse of
+// NewNewlineDelimitedFrameReader when `sep` is a newline. io.EOF is returned
+// once `r` is exhausted, any other error of `r` is returned as is.
+func NewFrameReaderIOReader(r FrameReader, sep []byte) io.Reader {
+	return &frameIOReader{r: r, sep: sep}
+}
+
+func (f *frameIOReader) Read(p []byte) (int, error) {
+	for len(f.pending) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+
+		frame, err := f.r.Read()
+		if err != nil {
+			f.err = err
+			continue
+		}
+
+		// The frame is copied since FrameReader may reuse its buffer.
+		f.buf = f.buf[:0]
+		if f.started {
+			f.buf = append(f.buf, f.sep...)
+		}
+		f.buf = append(f.buf, frame...)
+		f.pending = f.buf
+		f.started = true
+	}
+
+	n := copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,55 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameReaderIOReader(t *testing.T) {
+	frames := toFrames("a", "", "bcd", "e")
+
+	b, err := ioutil.ReadAll(NewFrameReaderIOReader(SliceFrameReader(frames), []byte("\n")))
+	require.NoError(t, err)
+	assert.Equal(t, "a\n\nbcd\ne", string(b))
+
+	b, err = ioutil.ReadAll(NewFrameReaderIOReader(SliceFrameReader(frames), nil))
+	require.NoError(t, err)
+	assert.Equal(t, "abcde", string(b))
+
+	// One byte at a time.
+	b, err = ioutil.ReadAll(iotest.OneByteReader(NewFrameReaderIOReader(SliceFrameReader(frames), []byte(", "))))
+	require.NoError(t, err)
+	assert.Equal(t, "a, , bcd, e", string(b))
+
+	b, err = ioutil.ReadAll(NewFrameReaderIOReader(SliceFrameReader(nil), []byte("\n")))
+	require.NoError(t, err)
+	assert.Empty(t, b)
+}
+
+func TestFrameReaderIOReaderRoundTrip(t *testing.T) {
+	payload := "first\nsecond\nthird"
+	r := NewFrameReaderIOReader(NewNewlineDelimitedFrameReader(bytes.NewBufferString(payload), false), []byte("\n"))
+	assert.NoError(t, iotest.TestReader(r, []byte(payload)))
+}
+
+func TestFrameReaderIOReaderError(t *testing.T) {
+	errRead := errors.New("read failed")
+	r := NewFrameReaderIOReader(MultiFrameReader(SliceFrameReader(toFrames("a")), failingFrameReader{errRead}), nil)
+
+	b, err := ioutil.ReadAll(r)
+	assert.ErrorIs(t, err, errRead)
+	assert.Equal(t, "a", string(b))
+
+	_, err = r.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, errRead)
+	assert.NotErrorIs(t, err, io.EOF)
+}
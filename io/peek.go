@@ -0,0 +1,41 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+// PeekableFrameReader is a FrameReader with one frame of look-ahead, e.g. to
+// inspect a header frame before handing the reader to a generic consumer.
+type PeekableFrameReader struct {
+	r FrameReader
+
+	// The result of the last Peek, not yet consumed by Read.
+	peeked bool
+	frame  []byte
+	err    error
+}
+
+// NewPeekableFrameReader wraps a FrameReader in a PeekableFrameReader.
+func NewPeekableFrameReader(r FrameReader) *PeekableFrameReader {
+	return &PeekableFrameReader{r: r}
+}
+
+// Peek returns the next frame without consuming it, the following Read
+// returns the same frame, or error. Successive calls to Peek return the same
+// frame. The frame is only valid until the following Read.
+func (p *PeekableFrameReader) Peek() ([]byte, error) {
+	if !p.peeked {
+		p.frame, p.err = p.r.Read()
+		p.peeked = true
+	}
+	return p.frame, p.err
+}
+
+// Read returns the peeked frame if any, otherwise reads the next frame.
+func (p *PeekableFrameReader) Read() ([]byte, error) {
+	if !p.peeked {
+		return p.r.Read()
+	}
+
+	frame, err := p.frame, p.err
+	p.peeked, p.frame, p.err = false, nil, nil
+	return frame, err
+}
@@ -0,0 +1,42 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeekableFrameReader(t *testing.T) {
+	r := NewPeekableFrameReader(NewNewlineDelimitedFrameReader(bytes.NewBufferString("header\na\nb"), false))
+
+	for i := 0; i < 2; i++ {
+		frame, err := r.Peek()
+		require.NoError(t, err)
+		assert.Equal(t, []byte("header"), frame)
+	}
+
+	frames, err := ReadAllFrames(r)
+	require.NoError(t, err)
+	assert.Equal(t, toFrames("header", "a", "b"), frames)
+
+	_, err = r.Peek()
+	assert.ErrorIs(t, err, io.EOF)
+	_, err = r.Read()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestPeekableFrameReaderReturnsPeekedError(t *testing.T) {
+	errRead := errors.New("read failed")
+	r := NewPeekableFrameReader(failingFrameReader{errRead})
+
+	_, err := r.Peek()
+	assert.ErrorIs(t, err, errRead)
+	_, err = r.Read()
+	assert.ErrorIs(t, err, errRead)
+}
@@ -0,0 +1,37 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"io"
+	"time"
+)
+
+type retryWriter struct {
+	w          io.Writer
+	maxRetries int
+	backoff    func(attempt int) time.Duration
+}
+
+// NewRetryWriter returns an io.Writer retrying failed writes to `w` up to
+// `maxRetries` times, e.g. for a flaky network sink. Before the n-th retry,
+// starting at 1, it waits `backoff(n)`, a nil backoff retries immediately. If
+// all attempts fail, the last error is returned.
+//
+// A write is only retried when no byte was written, otherwise retrying would
+// duplicate the bytes written, the partial write and its error are returned
+// as is.
+func NewRetryWriter(w io.Writer, maxRetries int, backoff func(attempt int) time.Duration) io.Writer {
+	return &retryWriter{w: w, maxRetries: maxRetries, backoff: backoff}
+}
+
+func (r *retryWriter) Write(p []byte) (int, error) {
+	n, err := r.w.Write(p)
+	for attempt := 1; err != nil && n == 0 && attempt <= r.maxRetries; attempt++ {
+		if r.backoff != nil {
+			time.Sleep(r.backoff(attempt))
+		}
+		n, err = r.w.Write(p)
+	}
+	return n, err
+}
@@ -0,0 +1,62 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyWriter fails the first `failures` writes, writing `partial` bytes.
+type flakyWriter struct {
+	bytes.Buffer
+	failures int
+	partial  int
+	writes   int
+}
+
+var errFlaky = errors.New("flaky write")
+
+func (f *flakyWriter) Write(p []byte) (int, error) {
+	f.writes++
+	if f.writes <= f.failures {
+		n, _ := f.Buffer.Write(p[:f.partial])
+		return n, errFlaky
+	}
+	return f.Buffer.Write(p)
+}
+
+func TestRetryWriter(t *testing.T) {
+	var attempts []int
+	backoff := func(attempt int) time.Duration {
+		attempts = append(attempts, attempt)
+		return time.Millisecond
+	}
+
+	w := &flakyWriter{failures: 2}
+	n, err := NewRetryWriter(w, 3, backoff).Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "hello", w.String())
+	assert.Equal(t, []int{1, 2}, attempts)
+
+	// All attempts fail.
+	w = &flakyWriter{failures: 10}
+	_, err = NewRetryWriter(w, 3, nil).Write([]byte("hello"))
+	assert.ErrorIs(t, err, errFlaky)
+	assert.Equal(t, 4, w.writes)
+	assert.Zero(t, w.Len())
+}
+
+func TestRetryWriterPartialWrite(t *testing.T) {
+	w := &flakyWriter{failures: 1, partial: 2}
+	n, err := NewRetryWriter(w, 3, nil).Write([]byte("hello"))
+	assert.ErrorIs(t, err, errFlaky)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 1, w.writes, "partial writes must not be retried")
+	assert.Equal(t, "he", w.String())
+}
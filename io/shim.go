@@ -4,8 +4,13 @@ package io
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"io"
 	"sync"
+	"sync/atomic"
+
+	perrors "github.com/optable/optable-pkglib/errors"
 )
 
 // NewBufferWriteCloserSize wraps an io.Writer in a buffer that is both flushed
@@ -44,36 +49,128 @@ func NewBufferWriteCloser(w io.Writer) io.WriteCloser {
 // composed io.Writer. Some of the chained writers may need to be closed, e.g.
 // os.File, net.Conn, gzip.Writer, etc. This wrapper takes care of closing the
 // writers in the proper order making the new owner oblivious of all the
-// involved hierarchy.
+// involved hierarchy. Closing more than once is safe, the closers are only
+// closed by the first call. A failing closer doesn't prevent the next ones
+// from being closed.
 func NewChainedCloser(w io.Writer, cs ...io.Closer) io.WriteCloser {
-	return &chainedCloser{Writer: w, cs: cs}
+	return &chainedCloser{Writer: w, closers: closers{cs: cs}}
 }
 
 type chainedCloser struct {
 	io.Writer
+	closers
+}
+
+// NewChainedReadCloser is the io.Reader counterpart of NewChainedCloser, e.g.
+// to close a gzip.Reader, then the http.Response's Body it decompresses.
+// Closing behaves as with NewChainedCloser.
+func NewChainedReadCloser(r io.Reader, cs ...io.Closer) io.ReadCloser {
+	return &chainedReadCloser{Reader: r, closers: closers{cs: cs}}
+}
+
+type chainedReadCloser struct {
+	io.Reader
+	closers
+}
+
+// closers implements the Close method of the chained closers.
+type closers struct {
 	cs []io.Closer
+
+	once sync.Once
+	err  error
 }
 
-func (w *chainedCloser) Close() error {
-	for _, c := range w.cs {
-		if err := c.Close(); err != nil {
-			return err
+// Close closes the chained closers once, subsequent calls return the result
+// of the first call instead of flushing or closing again. Every closer is
+// closed, in order, even if a previous one failed, e.g. the file descriptor is
+// closed even if flushing the buffer failed. Failures are aggregated with
+// errors.NewErrors, thus the returned error unwraps to the first failure.
+func (c *closers) Close() error {
+	c.once.Do(func() {
+		var errs []error
+		for _, closer := range c.cs {
+			errs = append(errs, closer.Close())
 		}
+		c.err = perrors.NewErrors(errs...)
+	})
+	return c.err
+}
+
+// CountingWriteCloser counts the bytes written through it, e.g. for logging
+// or billing the size of an export. See NewCountingWriteCloser.
+type CountingWriteCloser struct {
+	w     io.Writer
+	count int64
+}
+
+// NewCountingWriteCloser wraps an io.Writer counting the bytes written to it.
+// Closing closes the writer if it implements io.Closer, see MaybeClose. The
+// count depends on the position in the write stack, e.g. wrapped by
+// NewBufferWriteCloser it counts the flushed bytes, while wrapping a
+// gzip.Writer counts the uncompressed bytes.
+func NewCountingWriteCloser(w io.Writer) *CountingWriteCloser {
+	return &CountingWriteCloser{w: w}
+}
+
+func (c *CountingWriteCloser) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(&c.count, int64(n))
+	return n, err
+}
+
+func (c *CountingWriteCloser) Close() error {
+	return MaybeClose(c.w)
+}
+
+// Count returns the number of bytes written so far. It is safe to call
+// concurrently with Write, e.g. to report progress.
+func (c *CountingWriteCloser) Count() int64 {
+	return atomic.LoadInt64(&c.count)
+}
+
+// NewContextWriteCloser wraps an io.Writer failing with ctx.Err() once ctx is
+// done, e.g. to abort a long upload. Since a Write can't be preempted, ctx is
+// checked at the start of each Write, thus a Write blocked in `w` isn't
+// interrupted. Close still closes `w` if it implements io.Closer, such that
+// resources are released, and reports ctx.Err() along its error, see
+// errors.NewErrors.
+func NewContextWriteCloser(ctx context.Context, w io.Writer) io.WriteCloser {
+	return &contextWriteCloser{ctx: ctx, w: w}
+}
+
+type contextWriteCloser struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c *contextWriteCloser) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
 	}
+	return c.w.Write(p)
+}
 
-	return nil
+func (c *contextWriteCloser) Close() error {
+	return perrors.NewErrors(c.ctx.Err(), MaybeClose(c.w))
 }
 
 type closeOnce struct {
 	closer io.Closer
 	err    error
 	once   sync.Once
+	strict bool
 }
 
 func (c *closeOnce) Close() error {
+	first := false
 	c.once.Do(func() {
+		first = true
 		c.err = c.closer.Close()
 	})
+	if c.strict && !first {
+		return ErrAlreadyClosed
+	}
 	return c.err
 }
 
@@ -82,6 +179,17 @@ func SafeCloser(closer io.Closer) io.Closer {
 	return &closeOnce{closer: closer}
 }
 
+// ErrAlreadyClosed is returned by the closers of StrictSafeCloser when closed
+// more than once.
+var ErrAlreadyClosed = errors.New("Already closed")
+
+// StrictSafeCloser behaves like SafeCloser, i.e. the closer is closed exactly
+// once, except that the calls following the first one return ErrAlreadyClosed
+// instead of the first call's result. This helps detecting double-close bugs.
+func StrictSafeCloser(closer io.Closer) io.Closer {
+	return &closeOnce{closer: closer, strict: true}
+}
+
 // MaybeClose closes if the passed object implements io.Closer
 // and does nothing otherwise
 func MaybeClose(i interface{}) error {
@@ -4,10 +4,15 @@ package io
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"io"
+	"io/ioutil"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBufferWriterCloser(t *testing.T) {
@@ -61,3 +66,157 @@ func TestChainedCloser(t *testing.T) {
 	assert.Equal(t, 2, b)
 	assert.Equal(t, 3, c)
 }
+
+func TestChainedCloserIsIdempotent(t *testing.T) {
+	var calls [2]int
+	closer := func(i int, err error) io.Closer {
+		return CloserFn(func() error {
+			calls[i]++
+			return err
+		})
+	}
+
+	wc := NewChainedCloser(new(bytes.Buffer), closer(0, nil), closer(1, nil))
+	assert.NoError(t, wc.Close())
+	assert.NoError(t, wc.Close())
+	assert.Equal(t, [2]int{1, 1}, calls)
+
+	calls = [2]int{}
+	errClose := errors.New("close failed")
+	wc = NewChainedCloser(new(bytes.Buffer), closer(0, errClose), closer(1, nil))
+	assert.ErrorIs(t, wc.Close(), errClose)
+	assert.ErrorIs(t, wc.Close(), errClose, "the first result is returned")
+	assert.Equal(t, [2]int{1, 1}, calls)
+
+	// The buffer is not flushed twice.
+	buf := new(bytes.Buffer)
+	wc = NewBufferWriteCloser(buf)
+	_, err := wc.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, wc.Close())
+	assert.NoError(t, wc.Close())
+	assert.Equal(t, "hello", buf.String())
+}
+
+func TestChainedCloserClosesAllOnFailure(t *testing.T) {
+	errFlush, errFile := errors.New("flush failed"), errors.New("close failed")
+
+	var closed []string
+	closer := func(name string, err error) io.Closer {
+		return CloserFn(func() error {
+			closed = append(closed, name)
+			return err
+		})
+	}
+
+	wc := NewChainedCloser(new(bytes.Buffer), closer("buffer", errFlush), closer("gzip", nil), closer("file", errFile))
+	err := wc.Close()
+	assert.Equal(t, []string{"buffer", "gzip", "file"}, closed)
+	assert.ErrorIs(t, err, errFlush)
+	assert.ErrorIs(t, err, errFile)
+	assert.Equal(t, errFlush, errors.Unwrap(err), "unwraps to the first failure")
+}
+
+func TestChainedReadCloser(t *testing.T) {
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	_, err := gz.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	var closed []string
+	errBody := errors.New("close failed")
+	body := struct {
+		io.Reader
+		io.Closer
+	}{buf, CloserFn(func() error { closed = append(closed, "body"); return errBody })}
+
+	gzr, err := gzip.NewReader(body)
+	require.NoError(t, err)
+	rc := NewChainedReadCloser(gzr, gzr, CloserFn(func() error { closed = append(closed, "gzip"); return nil }), body)
+
+	payload, err := ioutil.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(payload))
+
+	assert.ErrorIs(t, rc.Close(), errBody)
+	assert.ErrorIs(t, rc.Close(), errBody, "the first result is returned")
+	assert.Equal(t, []string{"gzip", "body"}, closed)
+}
+
+func TestCountingWriteCloser(t *testing.T) {
+	buf := new(bytes.Buffer)
+	var closed bool
+	sink := NewChainedCloser(buf, CloserFn(func() error { closed = true; return nil }))
+
+	counting := NewCountingWriteCloser(sink)
+	wc := NewBufferWriteCloserSize(counting, 32)
+
+	_, err := wc.Write([]byte("helloworld"))
+	assert.NoError(t, err)
+	// Buffered, nothing reached the counting writer yet.
+	assert.Equal(t, int64(0), counting.Count())
+
+	assert.NoError(t, wc.Close())
+	assert.Equal(t, int64(10), counting.Count())
+	assert.Equal(t, "helloworld", buf.String())
+	assert.True(t, closed, "the wrapped writer is closed")
+
+	// Writers without Close.
+	counting = NewCountingWriteCloser(new(bytes.Buffer))
+	_, err = counting.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.NoError(t, counting.Close())
+	assert.Equal(t, int64(3), counting.Count())
+}
+
+func TestContextWriteCloser(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	buf := new(bytes.Buffer)
+	var closed bool
+	sink := NewChainedCloser(buf, CloserFn(func() error { closed = true; return nil }))
+
+	wc := NewContextWriteCloser(ctx, sink)
+	_, err := wc.Write([]byte("hello"))
+	assert.NoError(t, err)
+
+	cancel()
+	n, err := wc.Write([]byte("world"))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, "hello", buf.String())
+
+	assert.ErrorIs(t, wc.Close(), context.Canceled)
+	assert.True(t, closed, "the wrapped writer is closed even once cancelled")
+
+	// Buffered writes fail on flush.
+	wc = NewBufferWriteCloser(NewContextWriteCloser(ctx, new(bytes.Buffer)))
+	_, err = wc.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, wc.Close(), context.Canceled)
+
+	assert.NoError(t, NewContextWriteCloser(context.Background(), new(bytes.Buffer)).Close())
+}
+
+func TestSafeCloser(t *testing.T) {
+	errClose := errors.New("close failed")
+	var calls int
+	closer := CloserFn(func() error { calls++; return errClose })
+
+	safe := SafeCloser(closer)
+	assert.ErrorIs(t, safe.Close(), errClose)
+	assert.ErrorIs(t, safe.Close(), errClose, "the first result is memoized")
+	assert.Equal(t, 1, calls)
+
+	calls = 0
+	strict := StrictSafeCloser(closer)
+	assert.ErrorIs(t, strict.Close(), errClose)
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, strict.Close(), ErrAlreadyClosed)
+	}
+	assert.Equal(t, 1, calls)
+
+	strict = StrictSafeCloser(CloserFn(func() error { return nil }))
+	assert.NoError(t, strict.Close())
+	assert.ErrorIs(t, strict.Close(), ErrAlreadyClosed)
+}
@@ -0,0 +1,58 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"context"
+	"time"
+)
+
+// NewThrottledFrameWriter paces writes to `bytesPerSec`, see
+// NewThrottledFrameWriterContext.
+func NewThrottledFrameWriter(w FrameWriter, bytesPerSec int64) FrameWriter {
+	return NewThrottledFrameWriterContext(context.Background(), w, bytesPerSec)
+}
+
+// NewThrottledFrameWriterContext paces writes such that the throughput, in
+// bytes of payload, stays near `bytesPerSec`. This avoids saturating
+// downstream systems during bulk exports. A rate of 0 (or less) means
+// unlimited.
+//
+// Pacing follows a token bucket refilled at `bytesPerSec` which holds at most
+// one second worth of tokens and starts empty. Write blocks until the bucket
+// pays for the payload, frames larger than the bucket put it in debt. If the
+// context is cancelled while waiting, Write returns the context's error
+// without writing.
+func NewThrottledFrameWriterContext(ctx context.Context, w FrameWriter, bytesPerSec int64) FrameWriter {
+	if bytesPerSec <= 0 {
+		return w
+	}
+
+	rate := float64(bytesPerSec)
+	tokens := 0.0
+	last := time.Now()
+	return FrameWriterFunc(func(payload []byte) (int, error) {
+		now := time.Now()
+		tokens += now.Sub(last).Seconds() * rate
+		if tokens > rate {
+			tokens = rate
+		}
+		last = now
+
+		tokens -= float64(len(payload))
+		if tokens < 0 {
+			wait := time.Duration(-tokens / rate * float64(time.Second))
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				// The payload wasn't written, refund it.
+				tokens += float64(len(payload))
+				return 0, ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		return w.Write(payload)
+	})
+}
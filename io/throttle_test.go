@@ -0,0 +1,51 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottledFrameWriter(t *testing.T) {
+	const (
+		rate    = 100 * 1024
+		frames  = 200
+		payload = 100
+	)
+
+	w := NewThrottledFrameWriter(NewVarLenFrameWriter(ioutil.Discard), rate)
+
+	start := time.Now()
+	for i := 0; i < frames; i++ {
+		_, err := w.Write(make([]byte, payload))
+		assert.NoError(t, err)
+	}
+	elapsed := time.Since(start)
+
+	expected := time.Duration(float64(frames*payload) / rate * float64(time.Second))
+	assert.InDelta(t, expected.Seconds(), elapsed.Seconds(), expected.Seconds()/2)
+}
+
+func TestThrottledFrameWriterUnlimited(t *testing.T) {
+	w := NewThrottledFrameWriter(NewVarLenFrameWriter(ioutil.Discard), 0)
+
+	start := time.Now()
+	_, err := w.Write(make([]byte, 1024*1024))
+	assert.NoError(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+}
+
+func TestThrottledFrameWriterCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w := NewThrottledFrameWriterContext(ctx, NewVarLenFrameWriter(ioutil.Discard), 1)
+	n, err := w.Write([]byte("payload"))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, n)
+}
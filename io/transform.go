@@ -0,0 +1,315 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	perrors "github.com/optable/optable-pkglib/errors"
+)
+
+type windowFrameReader struct {
+	r      FrameReader
+	size   int
+	reduce func(frames [][]byte) []byte
+
+	// Copies of the last frames, oldest first.
+	frames [][]byte
+}
+
+// NewWindowFrameReader returns a FrameReader computing over a sliding window
+// of the last `window` frames of `r`, e.g. a moving aggregate. Each frame read
+// from `r` yields the output of `reduce` over the window ending with said
+// frame, oldest frame first.
+//
+// During warm-up, i.e. until `window` frames are read, no frame is emitted.
+// Thus a FrameReader with less than `window` frames yields no frame at all. A
+// window smaller than 1 is treated as 1.
+//
+// The frames are copied since FrameReader may reuse their buffer, `reduce`
+// must not retain the slice it is given.
+func NewWindowFrameReader(r FrameReader, window int, reduce func(frames [][]byte) []byte) FrameReader {
+	if window < 1 {
+		window = 1
+	}
+	return &windowFrameReader{r: r, size: window, reduce: reduce, frames: make([][]byte, 0, window)}
+}
+
+func (w *windowFrameReader) Read() ([]byte, error) {
+	for {
+		frame, err := w.r.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		var buf []byte
+		if len(w.frames) == w.size {
+			// Recycle the buffer of the evicted frame.
+			buf = w.frames[0][:0]
+			copy(w.frames, w.frames[1:])
+			w.frames = w.frames[:w.size-1]
+		}
+		w.frames = append(w.frames, append(buf, frame...))
+
+		if len(w.frames) == w.size {
+			return w.reduce(w.frames), nil
+		}
+	}
+}
+
+type normalizedFrameReader struct {
+	r             FrameReader
+	preserveEmpty bool
+
+	// Number of empty frames read but not yet emitted, and the non-empty frame
+	// that followed them.
+	pendingEmpty int
+	next         []byte
+	// Error following the pending empty frames, returned once they are.
+	err error
+}
+
+// NormalizeFrameReader drops the empty frames found at the end of the stream.
+// This addresses the mismatch between NewNewlineDelimitedFrameWriter, which
+// doesn't terminate the last frame, and files terminated by one or more
+// newlines which NewNewlineDelimitedFrameReader (without skipEmpty) reads as
+// trailing empty frames. Empty frames within the stream are preserved if
+// `preserveEmpty` is true, and dropped otherwise.
+//
+// All the trailing empty frames are dropped, not only the last one, such that
+// files terminated by any number of newlines read alike. Thus a stream ending
+// with empty frames on purpose is not preserved.
+//
+// Empty frames are only emitted once a non-empty frame, or an error other than
+// io.EOF, follows them, thus the reader may read ahead an arbitrary number of
+// empty frames.
+func NormalizeFrameReader(r FrameReader, preserveEmpty bool) FrameReader {
+	return &normalizedFrameReader{r: r, preserveEmpty: preserveEmpty}
+}
+
+func (n *normalizedFrameReader) Read() ([]byte, error) {
+	for n.next == nil && n.err == nil {
+		frame, err := n.r.Read()
+		if errors.Is(err, io.EOF) {
+			// Trailing empty frames are dropped.
+			n.pendingEmpty = 0
+			return nil, err
+		} else if err != nil {
+			n.err = err
+			break
+		}
+
+		if len(frame) > 0 {
+			n.next = frame
+		} else if n.preserveEmpty {
+			n.pendingEmpty++
+		}
+	}
+
+	if n.pendingEmpty > 0 {
+		n.pendingEmpty--
+		return []byte{}, nil
+	}
+
+	if err := n.err; err != nil {
+		n.err = nil
+		return nil, err
+	}
+
+	frame := n.next
+	n.next = nil
+	return frame, nil
+}
+
+// NewJSONTransformFrameReader returns a FrameReader reshaping JSON object
+// frames of `r`, e.g. renaming or moving fields during a migration. Each frame
+// is unmarshalled into a map, passed to `transform`, and the returned map is
+// marshalled back as the emitted frame.
+//
+// Unmarshalling, transform and marshalling errors are wrapped in a
+// PositionalError carrying the index of the faulty frame. Errors of `r` are
+// returned as is.
+func NewJSONTransformFrameReader(r FrameReader, transform func(map[string]interface{}) (map[string]interface{}, error)) FrameReader {
+	index := 0
+	return FrameReaderFunc(func() ([]byte, error) {
+		frame, err := r.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		pos := index
+		index++
+
+		var record map[string]interface{}
+		if err := json.Unmarshal(frame, &record); err != nil {
+			return nil, perrors.NewPositionalError(pos, fmt.Errorf("Failed unmarshalling frame: %w", err))
+		}
+
+		record, err = transform(record)
+		if err != nil {
+			return nil, perrors.NewPositionalError(pos, fmt.Errorf("Failed transforming frame: %w", err))
+		}
+
+		out, err := json.Marshal(record)
+		if err != nil {
+			return nil, perrors.NewPositionalError(pos, fmt.Errorf("Failed marshalling frame: %w", err))
+		}
+		return out, nil
+	})
+}
+
+type coalescingFrameReader struct {
+	r          FrameReader
+	targetSize int
+	sep        []byte
+
+	// The frames accumulated so far, joined by sep, and whether the batch holds
+	// at least one frame since frames may be empty.
+	batch    []byte
+	buffered bool
+	// The error which ended the accumulation, returned once the batch is
+	// emitted.
+	err error
+}
+
+// NewCoalescingFrameReader returns a FrameReader merging consecutive frames of
+// `r`, joined by `sep`, into frames of approximately `targetSize` bytes, e.g.
+// for downstream systems favoring large batches. Frames are accumulated until
+// adding another one would exceed `targetSize`, the separators included. A
+// frame larger than `targetSize` is emitted alone.
+//
+// The last batch is emitted before io.EOF, or any other error of `r`.
+func NewCoalescingFrameReader(r FrameReader, targetSize int, sep []byte) FrameReader {
+	return &coalescingFrameReader{r: r, targetSize: targetSize, sep: sep}
+}
+
+func (c *coalescingFrameReader) Read() ([]byte, error) {
+	for c.err == nil {
+		frame, err := c.r.Read()
+		if err != nil {
+			c.err = err
+			break
+		}
+
+		if !c.buffered {
+			c.batch = append(c.batch[:0], frame...)
+			c.buffered = true
+			continue
+		}
+
+		if len(c.batch)+len(c.sep)+len(frame) > c.targetSize {
+			// The batch is full, emit it and start a new one with the frame.
+			out := c.batch
+			c.batch = append([]byte(nil), frame...)
+			return out, nil
+		}
+
+		c.batch = append(append(c.batch, c.sep...), frame...)
+	}
+
+	if c.buffered {
+		c.buffered = false
+		out := c.batch
+		c.batch = nil
+		return out, nil
+	}
+
+	return nil, c.err
+}
+
+// MapFrameReader returns a FrameReader filtering and transforming the frames
+// of `r`, e.g. to drop invalid records or normalize a field. For each frame,
+// `fn` returns the replacing payload and whether to keep it, a dropped frame
+// is skipped. An error of `fn` aborts the read and is returned as is.
+func MapFrameReader(r FrameReader, fn func([]byte) ([]byte, bool, error)) FrameReader {
+	return FrameReaderFunc(func() ([]byte, error) {
+		for {
+			frame, err := r.Read()
+			if err != nil {
+				return nil, err
+			}
+
+			frame, keep, err := fn(frame)
+			if err != nil {
+				return nil, err
+			} else if keep {
+				return frame, nil
+			}
+		}
+	})
+}
+
+// TakeFrameReader returns a FrameReader limited to the first `n` frames of
+// `r`, e.g. for previews or sampling. Once `n` frames are read, io.EOF is
+// returned without reading `r` any further, thus `r` is left positioned after
+// the n-th frame.
+func TakeFrameReader(r FrameReader, n int) FrameReader {
+	return FrameReaderFunc(func() ([]byte, error) {
+		if n <= 0 {
+			return nil, io.EOF
+		}
+
+		frame, err := r.Read()
+		if err != nil {
+			return nil, err
+		}
+		n--
+		return frame, nil
+	})
+}
+
+// SkipFrameReader returns a FrameReader discarding the first `k` frames of
+// `r`, e.g. to skip a header block or resume processing. The frames are
+// discarded on the first Read. If `r` is exhausted before `k` frames, io.EOF
+// is returned.
+func SkipFrameReader(r FrameReader, k int) FrameReader {
+	return FrameReaderFunc(func() ([]byte, error) {
+		for ; k > 0; k-- {
+			if _, err := r.Read(); err != nil {
+				return nil, err
+			}
+		}
+		return r.Read()
+	})
+}
+
+// DedupFrameReader returns a FrameReader skipping the repeated frames of `r`,
+// e.g. duplicate identifiers in an export. The first occurrence of a frame is
+// emitted, its repeats are skipped.
+//
+// The memory trade-off is chosen with `consecutiveOnly`:
+//   - false: every repeat is skipped. The SHA-256 hash of every distinct frame
+//     is kept, thus memory grows with the number of distinct frames, roughly
+//     100 bytes each, and is unbounded on an infinite stream.
+//   - true: only the repeats adjacent to their previous occurrence are
+//     skipped, e.g. on a sorted stream. Only a copy of the previous frame is
+//     kept, thus memory is bounded by the largest frame.
+func DedupFrameReader(r FrameReader, consecutiveOnly bool) FrameReader {
+	if consecutiveOnly {
+		var prev []byte
+		seen := false
+		return MapFrameReader(r, func(frame []byte) ([]byte, bool, error) {
+			if seen && bytes.Equal(prev, frame) {
+				return nil, false, nil
+			}
+			// The frame is copied since FrameReader may reuse its buffer.
+			prev, seen = append(prev[:0], frame...), true
+			return frame, true, nil
+		})
+	}
+
+	seen := make(map[[sha256.Size]byte]struct{})
+	return MapFrameReader(r, func(frame []byte) ([]byte, bool, error) {
+		hash := sha256.Sum256(frame)
+		if _, ok := seen[hash]; ok {
+			return nil, false, nil
+		}
+		seen[hash] = struct{}{}
+		return frame, true, nil
+	})
+}
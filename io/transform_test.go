@@ -0,0 +1,253 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package io
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	perrors "github.com/optable/optable-pkglib/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func toFrames(payloads ...string) [][]byte {
+	frames := make([][]byte, 0, len(payloads))
+	for _, payload := range payloads {
+		frames = append(frames, []byte(payload))
+	}
+	return frames
+}
+
+func concat(frames [][]byte) []byte {
+	return bytes.Join(frames, nil)
+}
+
+func TestWindowFrameReader(t *testing.T) {
+	r := NewWindowFrameReader(SliceFrameReader(toFrames("a", "b", "c", "d", "e")), 3, concat)
+	frames, err := ReadAllFrames(r)
+	assert.NoError(t, err)
+	assert.Equal(t, toFrames("abc", "bcd", "cde"), frames)
+
+	// Not enough frames to fill the window.
+	r = NewWindowFrameReader(SliceFrameReader(toFrames("a", "b")), 3, concat)
+	frames, err = ReadAllFrames(r)
+	assert.NoError(t, err)
+	assert.Empty(t, frames)
+}
+
+func TestNormalizeFrameReader(t *testing.T) {
+	for _, trailing := range []string{"", "\n", "\n\n", "\n\n\n"} {
+		payload := "a\n\nb" + trailing
+
+		r := NormalizeFrameReader(NewNewlineDelimitedFrameReader(bytes.NewBufferString(payload), false), true)
+		frames, err := ReadAllFrames(r)
+		assert.NoError(t, err)
+		assert.Equal(t, toFrames("a", "", "b"), frames, "%q", payload)
+
+		r = NormalizeFrameReader(NewNewlineDelimitedFrameReader(bytes.NewBufferString(payload), false), false)
+		frames, err = ReadAllFrames(r)
+		assert.NoError(t, err)
+		assert.Equal(t, toFrames("a", "b"), frames, "%q", payload)
+	}
+}
+
+func TestNormalizeFrameReaderErrors(t *testing.T) {
+	errRead := errors.New("read failed")
+	source := MultiFrameReader(SliceFrameReader(toFrames("a", "", "")), failingFrameReader{errRead})
+
+	// The empty frames preceding an error are not trailing.
+	r := NormalizeFrameReader(source, true)
+	for _, expected := range toFrames("a", "", "") {
+		frame, err := r.Read()
+		require.NoError(t, err)
+		assert.Equal(t, expected, frame)
+	}
+	_, err := r.Read()
+	assert.ErrorIs(t, err, errRead)
+}
+
+func TestNormalizeFrameReaderRoundTrip(t *testing.T) {
+	expected := toFrames("a", "", "b")
+
+	buf := new(bytes.Buffer)
+	w := NewNewlineDelimitedFrameWriter(buf)
+	for _, frame := range expected {
+		_, err := w.Write(frame)
+		assert.NoError(t, err)
+	}
+	// Files produced by other tools usually terminate the last line.
+	buf.WriteString("\n")
+
+	r := NormalizeFrameReader(NewNewlineDelimitedFrameReader(buf, false), true)
+	frames, err := ReadAllFrames(r)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, frames)
+}
+
+func TestJSONTransformFrameReader(t *testing.T) {
+	rename := func(record map[string]interface{}) (map[string]interface{}, error) {
+		record["email"] = record["mail"]
+		delete(record, "mail")
+		return record, nil
+	}
+
+	r := NewJSONTransformFrameReader(SliceFrameReader(toFrames(`{"id":1,"mail":"a@b.c"}`, `{"id":2,"mail":null}`)), rename)
+	frames, err := ReadAllFrames(r)
+	require.NoError(t, err)
+	assert.Equal(t, toFrames(`{"email":"a@b.c","id":1}`, `{"email":null,"id":2}`), frames)
+}
+
+func TestJSONTransformFrameReaderErrors(t *testing.T) {
+	errTransform := errors.New("missing id")
+	requireID := func(record map[string]interface{}) (map[string]interface{}, error) {
+		if _, ok := record["id"]; !ok {
+			return nil, errTransform
+		}
+		return record, nil
+	}
+
+	r := NewJSONTransformFrameReader(SliceFrameReader(toFrames(`{"id":1}`, `{"name":"x"}`, `not json`)), requireID)
+
+	frame, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, []byte(`{"id":1}`), frame)
+
+	_, err = r.Read()
+	assert.ErrorIs(t, err, errTransform)
+	var posErr *perrors.PositionalError
+	require.ErrorAs(t, err, &posErr)
+	assert.Equal(t, 1, posErr.Position())
+
+	_, err = r.Read()
+	require.ErrorAs(t, err, &posErr)
+	assert.Equal(t, 2, posErr.Position())
+}
+
+func TestCoalescingFrameReader(t *testing.T) {
+	frames := toFrames("aa", "bb", "c", "dddddd", "e", "", "ff")
+	sep := []byte(",")
+
+	r := NewCoalescingFrameReader(SliceFrameReader(frames), 5, sep)
+	coalesced, err := ReadAllFrames(r)
+	require.NoError(t, err)
+	assert.Equal(t, toFrames("aa,bb", "c", "dddddd", "e,,ff"), coalesced)
+
+	for _, frame := range coalesced {
+		if len(bytes.Split(frame, sep)) > 1 {
+			assert.LessOrEqual(t, len(frame), 5, "only oversized single frames may exceed the target")
+		}
+	}
+
+	// No content is lost.
+	assert.Equal(t, bytes.Join(frames, sep), bytes.Join(coalesced, sep))
+
+	coalesced, err = ReadAllFrames(NewCoalescingFrameReader(SliceFrameReader(nil), 5, sep))
+	require.NoError(t, err)
+	assert.Empty(t, coalesced)
+}
+
+func TestCoalescingFrameReaderEmitsBatchBeforeError(t *testing.T) {
+	errRead := errors.New("read failed")
+	r := NewCoalescingFrameReader(MultiFrameReader(SliceFrameReader(toFrames("a", "b")), failingFrameReader{errRead}), 10, []byte(","))
+
+	frame, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("a,b"), frame)
+
+	_, err = r.Read()
+	assert.ErrorIs(t, err, errRead)
+}
+
+// failingFrameReader always fails reading.
+type failingFrameReader struct {
+	err error
+}
+
+func (r failingFrameReader) Read() ([]byte, error) {
+	return nil, r.err
+}
+
+func TestMapFrameReader(t *testing.T) {
+	lowerNonEmpty := func(frame []byte) ([]byte, bool, error) {
+		return bytes.ToLower(frame), len(frame) > 0, nil
+	}
+
+	r := MapFrameReader(MultiFrameReader(SliceFrameReader(toFrames("A@B.C", "")), SliceFrameReader(toFrames("D@e.F"))), lowerNonEmpty)
+	frames, err := ReadAllFrames(r)
+	require.NoError(t, err)
+	assert.Equal(t, toFrames("a@b.c", "d@e.f"), frames)
+
+	errMap := errors.New("invalid frame")
+	failInvalid := func(frame []byte) ([]byte, bool, error) {
+		if bytes.Equal(frame, []byte("invalid")) {
+			return nil, false, errMap
+		}
+		return frame, true, nil
+	}
+
+	r = MapFrameReader(SliceFrameReader(toFrames("valid", "invalid", "valid")), failInvalid)
+	frame, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("valid"), frame)
+	_, err = r.Read()
+	assert.ErrorIs(t, err, errMap)
+}
+
+func TestTakeFrameReader(t *testing.T) {
+	source := SliceFrameReader(toFrames("a", "b", "c", "d"))
+
+	frames, err := ReadAllFrames(TakeFrameReader(source, 2))
+	require.NoError(t, err)
+	assert.Equal(t, toFrames("a", "b"), frames)
+
+	// The underlying reader is not drained.
+	frames, err = ReadAllFrames(source)
+	require.NoError(t, err)
+	assert.Equal(t, toFrames("c", "d"), frames)
+
+	frames, err = ReadAllFrames(TakeFrameReader(SliceFrameReader(toFrames("a")), 3))
+	require.NoError(t, err)
+	assert.Equal(t, toFrames("a"), frames)
+
+	frames, err = ReadAllFrames(TakeFrameReader(SliceFrameReader(toFrames("a")), 0))
+	require.NoError(t, err)
+	assert.Empty(t, frames)
+}
+
+func TestSkipFrameReader(t *testing.T) {
+	frames, err := ReadAllFrames(SkipFrameReader(SliceFrameReader(toFrames("header", "a", "b")), 1))
+	require.NoError(t, err)
+	assert.Equal(t, toFrames("a", "b"), frames)
+
+	frames, err = ReadAllFrames(SkipFrameReader(SliceFrameReader(toFrames("a", "b")), 0))
+	require.NoError(t, err)
+	assert.Equal(t, toFrames("a", "b"), frames)
+
+	r := SkipFrameReader(SliceFrameReader(toFrames("a", "b")), 5)
+	for i := 0; i < 2; i++ {
+		_, err = r.Read()
+		assert.ErrorIs(t, err, io.EOF)
+	}
+}
+
+func TestDedupFrameReader(t *testing.T) {
+	frames, err := ReadAllFrames(DedupFrameReader(SliceFrameReader(toFrames("a", "b", "a", "a", "", "c", "", "b")), false))
+	require.NoError(t, err)
+	assert.Equal(t, toFrames("a", "b", "", "c"), frames)
+
+	frames, err = ReadAllFrames(DedupFrameReader(SliceFrameReader(toFrames("", "", "a", "b", "a", "a", "c", "c")), true))
+	require.NoError(t, err)
+	assert.Equal(t, toFrames("", "a", "b", "a", "c"), frames)
+
+	// The frames of a reader reusing its buffer are compared by value.
+	payload := "a\na\nb\nb\na\n"
+	frames, err = ReadAllFrames(DedupFrameReader(NewVarLenFrameReader(bytes.NewReader(varLenPayload(t, toFrames("a", "a", "b", "b", "a")))), true))
+	require.NoError(t, err)
+	assert.Equal(t, toFrames("a", "b", "a"), frames)
+	frames, err = ReadAllFrames(DedupFrameReader(NewNewlineDelimitedFrameReader(bytes.NewBufferString(payload), true), false))
+	require.NoError(t, err)
+	assert.Equal(t, toFrames("a", "b"), frames)
+}
@@ -0,0 +1,25 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package lifecycle
+
+import (
+	"net/http"
+
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"google.golang.org/grpc"
+)
+
+// NewGrpcWebHandler returns an http.Handler serving the gRPC-Web requests,
+// including their CORS preflight and websocket transport when enabled, with
+// the grpc.Server. Other requests are served by fallback, e.g. NewMetricsMux.
+// See WithGrpcWeb to serve gRPC-Web with ServeGRPCAndMetrics.
+func NewGrpcWebHandler(server *grpc.Server, fallback http.Handler, opts ...grpcweb.Option) http.Handler {
+	wrapped := grpcweb.WrapServer(server, opts...)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wrapped.IsGrpcWebRequest(r) || wrapped.IsAcceptableGrpcCorsRequest(r) || wrapped.IsGrpcWebSocketRequest(r) {
+			wrapped.ServeHTTP(w, r)
+			return
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,67 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package lifecycle
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// postGrpcWebHealthCheck issues a grpc.health.v1 Check over gRPC-Web and
+// returns the raw response.
+func postGrpcWebHealthCheck(t *testing.T, addr string) (*http.Response, []byte) {
+	// A single uncompressed frame holding an empty HealthCheckRequest.
+	frame := []byte{0, 0, 0, 0, 0}
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/grpc.health.v1.Health/Check", bytes.NewReader(frame))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+func TestServeGRPCAndMetricsWithGrpcWeb(t *testing.T) {
+	l := requireLocalListener(t)
+	addr := l.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := ServeGRPCAndMetrics(ctx, l, newHealthGrpcServer(), testShutdownTimeout, WithGrpcWeb())
+
+	resp, body := postGrpcWebHealthCheck(t, addr)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/grpc-web+proto", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "grpc-status: 0")
+
+	assertGrpcServed(t, addr)
+	assertStatus(t, http.StatusOK, "http://"+addr+"/metrics")
+
+	cancel()
+	assert.NoError(t, <-errs)
+}
+
+func TestServeGRPCAndMetricsWithoutGrpcWeb(t *testing.T) {
+	l := requireLocalListener(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := ServeGRPCAndMetrics(ctx, l, newHealthGrpcServer(), testShutdownTimeout)
+
+	// Served by the metrics handler instead.
+	resp, body := postGrpcWebHealthCheck(t, l.Addr().String())
+	assert.NotContains(t, string(body), "grpc-status")
+	assert.NotEqual(t, "application/grpc-web+proto", resp.Header.Get("Content-Type"))
+
+	cancel()
+	assert.NoError(t, <-errs)
+}
@@ -0,0 +1,66 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package lifecycle
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Readiness is an http.Handler reporting whether the server is ready to accept
+// traffic, e.g. for Kubernetes readiness probes. It responds 200 when ready and
+// 503 otherwise. See WithReadiness to become unready during the graceful
+// shutdown.
+type Readiness struct {
+	notReady int32
+}
+
+// NewReadiness returns a Readiness which is initially ready.
+func NewReadiness() *Readiness {
+	return &Readiness{}
+}
+
+// SetReady changes the readiness.
+func (r *Readiness) SetReady(ready bool) {
+	var notReady int32
+	if !ready {
+		notReady = 1
+	}
+	atomic.StoreInt32(&r.notReady, notReady)
+}
+
+// Ready returns the current readiness.
+func (r *Readiness) Ready() bool {
+	return atomic.LoadInt32(&r.notReady) == 0
+}
+
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	if !r.Ready() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+// LivenessHandler returns an http.Handler always responding 200, e.g. for
+// Kubernetes liveness probes.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+}
+
+// NewMetricsMux returns the http.ServeMux served by ServeGrpcAndMetrics. It
+// exposes the prometheus metrics on every path except `/healthz` and `/readyz`
+// which are respectively served by LivenessHandler and `readiness`. Callers
+// can register additional handlers before serving it with ServeGRPCAndHTTP,
+// along WithReadiness(readiness).
+func NewMetricsMux(readiness *Readiness) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/", promhttp.Handler())
+	mux.Handle("/healthz", LivenessHandler())
+	mux.Handle("/readyz", readiness)
+	return mux
+}
@@ -0,0 +1,64 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package lifecycle
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadiness(t *testing.T) {
+	readiness := NewReadiness()
+	assert.True(t, readiness.Ready())
+
+	rec := httptest.NewRecorder()
+	readiness.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	readiness.SetReady(false)
+	rec = httptest.NewRecorder()
+	readiness.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func assertStatus(t *testing.T, expected int, url string) {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, expected, resp.StatusCode, url)
+}
+
+func TestServeGRPCAndMetricsProbes(t *testing.T) {
+	l := requireLocalListener(t)
+	addr := "http://" + l.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := ServeGRPCAndMetrics(ctx, l, newHealthGrpcServer(), testShutdownTimeout)
+
+	assertStatus(t, http.StatusOK, addr+"/metrics")
+	assertStatus(t, http.StatusOK, addr+"/healthz")
+	assertStatus(t, http.StatusOK, addr+"/readyz")
+
+	cancel()
+	assert.NoError(t, <-errs)
+}
+
+func TestServeWithReadiness(t *testing.T) {
+	l := requireLocalListener(t)
+	readiness := NewReadiness()
+
+	const drainDelay = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := ServeWithGracefulShutdown(ctx, l, newHealthGrpcServer(), testShutdownTimeout, WithReadiness(readiness), WithDrainDelay(drainDelay))
+
+	cancel()
+	assert.Eventually(t, func() bool { return !readiness.Ready() }, drainDelay, time.Millisecond)
+	assert.NoError(t, <-errs)
+}
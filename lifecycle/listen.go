@@ -0,0 +1,31 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// ErrAddrInUse is returned by Listen when the address is already bound, e.g.
+// by another instance of the service.
+var ErrAddrInUse = errors.New("address already in use")
+
+// Listen wraps net.Listen, the returned net.Listener is meant to be passed to
+// the serve helpers, e.g. ServeWithGracefulShutdown. On Unix, TCP listeners
+// are created with SO_REUSEADDR by the Go runtime, thus a restarted service
+// can bind the address of connections still in TIME_WAIT.
+//
+// Failing to bind an address already in use returns an error wrapping
+// ErrAddrInUse and naming the address.
+func Listen(network, addr string) (net.Listener, error) {
+	l, err := net.Listen(network, addr)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return nil, fmt.Errorf("Failed listening on %s %s, is another process bound to it?: %w", network, addr, ErrAddrInUse)
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed listening on %s %s: %w", network, addr, err)
+	}
+	return l, nil
+}
@@ -0,0 +1,25 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package lifecycle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListen(t *testing.T) {
+	l, err := Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	addr := l.Addr().String()
+	_, err = Listen("tcp", addr)
+	assert.ErrorIs(t, err, ErrAddrInUse)
+	assert.EqualError(t, err, "Failed listening on tcp "+addr+", is another process bound to it?: address already in use")
+
+	_, err = Listen("invalid", "127.0.0.1:0")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrAddrInUse)
+}
@@ -0,0 +1,93 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+// Outcomes of a shutdown counted by ShutdownMetrics.
+const (
+	// ShutdownGraceful is a shutdown completed within its budget.
+	ShutdownGraceful = "graceful"
+	// ShutdownForced is a grpc.Server shutdown which exceeded its budget and
+	// was stopped with Stop, aborting the in-flight RPCs.
+	ShutdownForced = "forced"
+	// ShutdownFailed is any other failed shutdown.
+	ShutdownFailed = "failed"
+)
+
+// ShutdownMetrics observes the duration and the outcome of graceful
+// shutdowns. This helps alerting on servers taking too long to drain.
+type ShutdownMetrics struct {
+	duration prometheus.Histogram
+	total    *prometheus.CounterVec
+}
+
+// NewShutdownMetrics creates ShutdownMetrics. The returned value is a
+// prometheus.Collector which must be registered, see
+// NewRegisteredShutdownMetrics.
+func NewShutdownMetrics() *ShutdownMetrics {
+	return &ShutdownMetrics{
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "shutdown_duration_seconds",
+			Help:    "Duration of the graceful shutdowns.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+		}),
+		total: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shutdown_total",
+			Help: "Total number of shutdowns by outcome, i.e. graceful, forced or failed.",
+		}, []string{"outcome"}),
+	}
+}
+
+// NewRegisteredShutdownMetrics creates ShutdownMetrics registered on the
+// given registry, e.g. prometheus.DefaultRegisterer as used by
+// service.NewGRPCService.
+func NewRegisteredShutdownMetrics(registry prometheus.Registerer) (*ShutdownMetrics, error) {
+	m := NewShutdownMetrics()
+	if err := registry.Register(m); err != nil {
+		return nil, fmt.Errorf("Failed registering shutdown metrics: %w", err)
+	}
+	return m, nil
+}
+
+func (m *ShutdownMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.duration.Describe(ch)
+	m.total.Describe(ch)
+}
+
+func (m *ShutdownMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.duration.Collect(ch)
+	m.total.Collect(ch)
+}
+
+// MaybeGracefulShutdown behaves like the package's MaybeGracefulShutdown and
+// observes the shutdown.
+func (m *ShutdownMetrics) MaybeGracefulShutdown(ctx context.Context, i interface{}) error {
+	start := time.Now()
+	err := MaybeGracefulShutdown(ctx, i)
+	m.duration.Observe(time.Since(start).Seconds())
+
+	outcome := ShutdownGraceful
+	if _, ok := i.(*grpc.Server); ok && err != nil {
+		// GracefulShutdownGrpcServer only fails once it stopped the server.
+		outcome = ShutdownForced
+	} else if err != nil {
+		outcome = ShutdownFailed
+	}
+	m.total.WithLabelValues(outcome).Inc()
+
+	return err
+}
+
+// GracefulShutdownGrpcServer behaves like the package's
+// GracefulShutdownGrpcServer and observes the shutdown.
+func (m *ShutdownMetrics) GracefulShutdownGrpcServer(ctx context.Context, server *grpc.Server) error {
+	return m.MaybeGracefulShutdown(ctx, server)
+}
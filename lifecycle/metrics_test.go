@@ -0,0 +1,63 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package lifecycle
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestShutdownMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewRegisteredShutdownMetrics(registry)
+	require.NoError(t, err)
+
+	_, err = NewRegisteredShutdownMetrics(registry)
+	assert.Error(t, err, "metrics can't be registered twice")
+
+	ctx := context.Background()
+	assert.NoError(t, m.MaybeGracefulShutdown(ctx, basic))
+	assert.NoError(t, m.GracefulShutdownGrpcServer(ctx, newHealthGrpcServer()))
+	assert.ErrorIs(t, m.MaybeGracefulShutdown(ctx, failShutdown), errShutdown)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	server := newHealthGrpcServer()
+	l := requireLocalListener(t)
+	go func() { _ = server.Serve(l) }()
+
+	// The in-flight stream prevents a graceful stop, the shutdown is forced as
+	// soon as the budget is exhausted.
+	conn, err := grpc.DialContext(ctx, l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	watch, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = watch.Recv()
+	require.NoError(t, err)
+	assert.ErrorIs(t, m.GracefulShutdownGrpcServer(cancelled, server), context.Canceled)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.total.WithLabelValues(ShutdownGraceful)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.total.WithLabelValues(ShutdownForced)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.total.WithLabelValues(ShutdownFailed)))
+	assert.Equal(t, 1, testutil.CollectAndCount(m, "shutdown_duration_seconds"))
+}
+
+func TestServeWithShutdownMetrics(t *testing.T) {
+	m := NewShutdownMetrics()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := ServeWithGracefulShutdown(ctx, requireLocalListener(t), newHealthGrpcServer(), testShutdownTimeout, WithShutdownMetrics(m))
+	cancel()
+	assert.NoError(t, <-errs)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.total.WithLabelValues(ShutdownGraceful)))
+}
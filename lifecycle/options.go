@@ -0,0 +1,156 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package lifecycle
+
+import (
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"google.golang.org/grpc/health"
+)
+
+type (
+	// ServeOption customizes the serving and shutdown sequence of the serve
+	// helpers, e.g. ServeWithGracefulShutdown.
+	ServeOption interface {
+		apply(opts *serveOptions)
+	}
+
+	serveOptions struct {
+		closeListener bool
+		health        *health.Server
+		signals       []os.Signal
+		drainDelay    time.Duration
+		readiness     *Readiness
+		onServing     func()
+		metrics       *ShutdownMetrics
+		grpcWeb       []grpcweb.Option
+	}
+
+	serveOptionFn func(opts *serveOptions)
+)
+
+func (fn serveOptionFn) apply(opts *serveOptions) {
+	fn(opts)
+}
+
+func newServeOptions(opts []ServeOption) *serveOptions {
+	options := &serveOptions{
+		signals: []os.Signal{syscall.SIGINT, syscall.SIGTERM},
+	}
+	for _, opt := range opts {
+		opt.apply(options)
+	}
+	return options
+}
+
+// WithListenerCloseOnShutdown closes the net.Listener at the start of the
+// shutdown sequence, before draining in-flight requests. New connections are
+// thus refused promptly instead of when the server closes the listener as a
+// side effect of its shutdown.
+func WithListenerCloseOnShutdown() ServeOption {
+	return serveOptionFn(func(opts *serveOptions) {
+		opts.closeListener = true
+	})
+}
+
+// WithHealthServer sets every service of the health.Server to NOT_SERVING at
+// the start of the shutdown sequence, such that health probes stop routing
+// traffic to the draining server.
+func WithHealthServer(h *health.Server) ServeOption {
+	return serveOptionFn(func(opts *serveOptions) {
+		opts.health = h
+	})
+}
+
+// WithShutdownSignals replaces the signals triggering the shutdown sequence,
+// SIGINT and SIGTERM by default. Passing no signal disables signal handling,
+// the shutdown is then solely triggered by the context cancellation.
+func WithShutdownSignals(signals ...os.Signal) ServeOption {
+	return serveOptionFn(func(opts *serveOptions) {
+		opts.signals = signals
+	})
+}
+
+// WithReadiness marks the Readiness as not ready at the start of the shutdown
+// sequence, such that readiness probes stop routing traffic to the draining
+// server.
+func WithReadiness(readiness *Readiness) ServeOption {
+	return serveOptionFn(func(opts *serveOptions) {
+		opts.readiness = readiness
+	})
+}
+
+// WithDrainDelay delays the shutdown of the server once triggered, the server
+// keeps serving during the delay. This gives time to load-balancers, e.g. the
+// Kubernetes endpoints controller, to deregister the server before it stops
+// accepting requests. Combine with WithHealthServer, or WithReadiness, such
+// that health probes report NOT_SERVING during the delay. The delay doesn't
+// count in the shutdown timeout.
+func WithDrainDelay(delay time.Duration) ServeOption {
+	return serveOptionFn(func(opts *serveOptions) {
+		opts.drainDelay = delay
+	})
+}
+
+// WithShutdownMetrics observes the shutdown of the server with the
+// ShutdownMetrics, see NewRegisteredShutdownMetrics.
+func WithShutdownMetrics(metrics *ShutdownMetrics) ServeOption {
+	return serveOptionFn(func(opts *serveOptions) {
+		opts.metrics = metrics
+	})
+}
+
+// WithGrpcWeb serves gRPC-Web requests with the grpc.Server on the HTTP1
+// side of ServeGRPCAndMetrics, see NewGrpcWebHandler. A single port then
+// serves native gRPC, gRPC-Web and the metrics. It is ignored by the other
+// serve helpers, use NewGrpcWebHandler with ServeGRPCAndHTTP instead.
+func WithGrpcWeb(webOpts ...grpcweb.Option) ServeOption {
+	return serveOptionFn(func(opts *serveOptions) {
+		// Never nil, gRPC-Web is enabled even without options.
+		opts.grpcWeb = append([]grpcweb.Option{}, webOpts...)
+	})
+}
+
+// WithOnServing invokes fn once the server started serving, i.e. on its first
+// call to the net.Listener's Accept. Since the listener is already bound,
+// connections dialed after fn is invoked are served, e.g. to order the startup
+// of dependent components or to write tests without sleeps. fn is never
+// invoked if the server fails before accepting connections.
+func WithOnServing(fn func()) ServeOption {
+	return serveOptionFn(func(opts *serveOptions) {
+		opts.onServing = fn
+	})
+}
+
+// onServingListener invokes onServing on the first Accept.
+type onServingListener struct {
+	net.Listener
+	once      sync.Once
+	onServing func()
+}
+
+func (l *onServingListener) Accept() (net.Conn, error) {
+	l.once.Do(l.onServing)
+	return l.Listener.Accept()
+}
+
+// onceCloseListener guards a net.Listener against multiple Close. Servers
+// usually close their listener on shutdown and some of them, e.g.
+// http.Server, report the error of a second Close.
+type onceCloseListener struct {
+	net.Listener
+	once sync.Once
+	err  error
+}
+
+func (l *onceCloseListener) Close() error {
+	l.once.Do(func() {
+		l.err = l.Listener.Close()
+	})
+	return l.err
+}
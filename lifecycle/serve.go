@@ -8,10 +8,10 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"syscall"
+	"sync"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	perrors "github.com/optable/optable-pkglib/errors"
 	"github.com/rs/zerolog"
 	"github.com/soheilhy/cmux"
 	"golang.org/x/sync/errgroup"
@@ -27,116 +27,395 @@ type (
 )
 
 // ServeWithGracefulShutdown glue a Servable with a proper shutdown routine.
-// register signals to trigger a proper shutdown sequence. This function does
-// not block and returns immediately a channel where an error will be emitted
-// if it failed to serve, or the returned shutdown error (or nil if none).
-func ServeWithGracefulShutdown(ctx context.Context, listen net.Listener, server Servable, shutdownTimeout time.Duration) <-chan error {
-	ctx, cancel := context.WithCancel(ctx)
+// register signals to trigger a proper shutdown sequence, see
+// WithShutdownSignals. This function does not block and returns immediately a
+// channel where an error will be emitted if it failed to serve, or the
+// returned shutdown error (or nil if none). See
+// ServeWithGracefulShutdownResult for a detailed outcome.
+func ServeWithGracefulShutdown(ctx context.Context, listen net.Listener, server Servable, shutdownTimeout time.Duration, opts ...ServeOption) <-chan error {
+	results := ServeWithGracefulShutdownResult(ctx, listen, server, shutdownTimeout, opts...)
 
+	shutdownCompleted := make(chan error, 1)
+	go func() {
+		defer close(shutdownCompleted)
+		if err := (<-results).Err(); err != nil {
+			shutdownCompleted <- err
+		}
+	}()
+
+	return shutdownCompleted
+}
+
+// ShutdownTrigger enumerates the events triggering a shutdown sequence.
+type ShutdownTrigger int
+
+const (
+	// TriggeredByServer is used when the server stopped serving on its own,
+	// usually because it failed.
+	TriggeredByServer ShutdownTrigger = iota
+	// TriggeredByContext is used when the serving context is cancelled.
+	TriggeredByContext
+	// TriggeredBySignal is used when a shutdown signal is received.
+	TriggeredBySignal
+)
+
+func (t ShutdownTrigger) String() string {
+	switch t {
+	case TriggeredByServer:
+		return "server"
+	case TriggeredByContext:
+		return "context"
+	case TriggeredBySignal:
+		return "signal"
+	default:
+		return "unknown"
+	}
+}
+
+// ShutdownResult is the outcome of ServeWithGracefulShutdownResult.
+type ShutdownResult struct {
+	// ServeErr is the error returned by the Servable's Serve method, if it
+	// stopped serving before the shutdown was triggered.
+	ServeErr error
+	// ShutdownErr is the error of the shutdown, see MaybeGracefulShutdown.
+	ShutdownErr error
+	// Duration of the shutdown sequence, from the trigger to its completion.
+	Duration time.Duration
+	// TriggeredBy is the event which triggered the shutdown.
+	TriggeredBy ShutdownTrigger
+	// Signal received, only set when TriggeredBy is TriggeredBySignal.
+	Signal os.Signal
+}
+
+// Err combines ServeErr and ShutdownErr in a single error, or nil if none.
+func (r ShutdownResult) Err() error {
+	var serveErr, shutdownErr error
+	if r.ServeErr != nil {
+		serveErr = fmt.Errorf("Server failed to listen: %w", r.ServeErr)
+	}
+	if r.ShutdownErr != nil {
+		shutdownErr = fmt.Errorf("Unclean shutdown of server: %w", r.ShutdownErr)
+	}
+	return perrors.NewErrors(serveErr, shutdownErr)
+}
+
+// ServeWithGracefulShutdownResult behaves like ServeWithGracefulShutdown
+// except that the returned channel emits a single ShutdownResult detailing the
+// outcome of the serving and the shutdown.
+func ServeWithGracefulShutdownResult(ctx context.Context, listen net.Listener, server Servable, shutdownTimeout time.Duration, opts ...ServeOption) <-chan ShutdownResult {
 	logger := zerolog.Ctx(ctx)
+	options := newServeOptions(opts)
+
+	if options.closeListener {
+		listen = &onceCloseListener{Listener: listen}
+	}
+	if options.onServing != nil {
+		listen = &onServingListener{Listener: listen, onServing: options.onServing}
+	}
 
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	// A nil channel never receives, thus disabling signal handling.
+	var signals chan os.Signal
+	if len(options.signals) > 0 {
+		signals = make(chan os.Signal, 1)
+		signal.Notify(signals, options.signals...)
+	}
 
-	shutdownCompleted := make(chan error, 1)
+	served := make(chan error, 1)
 	go func() {
-		defer close(shutdownCompleted)
-		defer logger.Info().Msg("Shutdown sequence completed")
+		logger.Info().
+			Str("addr", listen.Addr().String()).
+			Str("server", serverKind(server)).
+			Dur("shutdown_timeout", shutdownTimeout).
+			Msgf("Serving %s on %s", serverKind(server), listen.Addr())
+		served <- server.Serve(listen)
+	}()
 
-		var sig os.Signal
+	results := make(chan ShutdownResult, 1)
+	go func() {
+		defer close(results)
+		if signals != nil {
+			defer signal.Stop(signals)
+		}
+		var result ShutdownResult
 		select {
+		case result.ServeErr = <-served:
+			result.TriggeredBy = TriggeredByServer
+			logger.Info().Msg("Shutdown triggered by server termination")
 		case <-ctx.Done():
+			result.TriggeredBy = TriggeredByContext
 			logger.Info().Msg("Shutdown triggered by context cancellation")
-		case sig = <-signals:
-			logger.Info().Str("signal", sig.String()).Msgf("Shutdown triggered by signal: %s", sig)
+		case result.Signal = <-signals:
+			result.TriggeredBy = TriggeredBySignal
+			logger.Info().Str("signal", result.Signal.String()).Msgf("Shutdown triggered by signal: %s", result.Signal)
+		}
+		start := time.Now()
+
+		if options.health != nil {
+			options.health.Shutdown()
+		}
+		if options.readiness != nil {
+			options.readiness.SetReady(false)
 		}
 
-		ctx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+		// Keep serving while load-balancers deregister the server, unless it
+		// already stopped serving.
+		if options.drainDelay > 0 && result.TriggeredBy != TriggeredByServer {
+			logger.Info().Dur("drain_delay", options.drainDelay).Msg("Waiting drain delay before shutdown")
+			time.Sleep(options.drainDelay)
+		}
+
+		// The shutdown budget must not be tied to the (possibly cancelled)
+		// serving context, otherwise a cancellation would never be graceful.
+		ctx, cancel := context.WithTimeout(logger.WithContext(context.Background()), shutdownTimeout)
 		defer cancel()
 
-		if err := MaybeGracefulShutdown(ctx, server); err != nil {
-			shutdownCompleted <- fmt.Errorf("Unclean shutdown of grpc server: %w", err)
-			return
+		if options.closeListener {
+			_ = listen.Close()
+		}
+
+		// Even if the server stopped on its own, in-flight requests may still be
+		// running on already accepted connections and must be drained.
+		if options.metrics != nil {
+			result.ShutdownErr = options.metrics.MaybeGracefulShutdown(ctx, server)
+		} else {
+			result.ShutdownErr = MaybeGracefulShutdown(ctx, server)
 		}
+		result.Duration = time.Since(start)
+
+		logger.Info().
+			Stringer("triggered_by", result.TriggeredBy).
+			Dur("duration", result.Duration).
+			Msg("Shutdown sequence completed")
+		results <- result
 	}()
 
+	return results
+}
+
+type (
+	// ServableOnListener pairs a Servable with the Listener it serves. See
+	// ServeGroup.
+	ServableOnListener struct {
+		Listener net.Listener
+		Server   Servable
+	}
+
+	// MuxRoute pairs a cmux.Matcher with the Servable handling the connections
+	// it matches. See ServeMux.
+	MuxRoute struct {
+		Matcher cmux.Matcher
+		Server  Servable
+	}
+)
+
+// ServeGroup serves multiple Servables, each on its own Listener, under a
+// single shutdown lifecycle. Every member is served with
+// ServeWithGracefulShutdown, thus a signal or a context cancellation drains
+// all of them. If any member fails, the other members are shutdown
+// gracefully. The returned channel emits the aggregated errors of all members
+// (see errors.NewErrors), or nil if none.
+func ServeGroup(ctx context.Context, shutdownTimeout time.Duration, members ...ServableOnListener) <-chan error {
+	return serveGroup(ctx, shutdownTimeout, members, nil)
+}
+
+func serveGroup(ctx context.Context, shutdownTimeout time.Duration, members []ServableOnListener, opts []ServeOption) <-chan error {
+	errs := make(chan error, 1)
+
 	go func() {
-		if err := server.Serve(listen); err != nil {
-			cancel()
-			shutdownCompleted <- fmt.Errorf("Server failed to listen: %w", err)
+		defer close(errs)
+
+		group, ctx := errgroup.WithContext(ctx)
+
+		var (
+			mu       sync.Mutex
+			failures []error
+		)
+
+		for _, member := range members {
+			member := member
+			group.Go(func() error {
+				result := <-ServeWithGracefulShutdownResult(ctx, member.Listener, member.Server, shutdownTimeout, opts...)
+				if isClosedErr(result.ServeErr) {
+					result.ServeErr = nil
+				}
+				if isClosedErr(result.ShutdownErr) {
+					result.ShutdownErr = nil
+				}
+				err := result.Err()
+				if err == nil {
+					return nil
+				}
+
+				err = fmt.Errorf("Failed serving %T: %w", member.Server, err)
+				mu.Lock()
+				defer mu.Unlock()
+				failures = append(failures, err)
+				return err
+			})
 		}
+
+		// Any error is cascaded in a context cancellation for the other members.
+		_ = group.Wait()
+		errs <- perrors.NewErrors(failures...)
 	}()
 
-	return shutdownCompleted
+	return errs
 }
 
-// ServeGRPCAndHTTP behaves like ServeWithGracefulShutdown excepts that it
-// also starts an HTTP1 service on the same Listener to expose
-// metrics.
-func ServeGRPCAndHTTP(ctx context.Context, l net.Listener, handler http.Handler, server *grpc.Server, shutdownTimeout time.Duration) <-chan error {
+// ServeMux multiplexes a single Listener between multiple Servables. Each
+// connection is handed to the first route whose Matcher accepts it, thus the
+// order of routes matters and catch-all matchers, e.g. cmux.Any(), must come
+// last.
+//
+// The routes are served as a ServeGroup. If any route fails, the other routes
+// are shutdown gracefully. The returned channel emits the aggregated errors of
+// all routes (see errors.NewErrors), or nil if none. The ServeOptions apply to
+// every route, except WithOnServing which applies once to the Listener.
+func ServeMux(ctx context.Context, l net.Listener, routes []MuxRoute, shutdownTimeout time.Duration, opts ...ServeOption) <-chan error {
+	return serveMux(ctx, l, func(mux cmux.CMux) []ServableOnListener {
+		members := make([]ServableOnListener, 0, len(routes))
+		for _, route := range routes {
+			members = append(members, ServableOnListener{Listener: mux.Match(route.Matcher), Server: route.Server})
+		}
+		return members
+	}, shutdownTimeout, opts)
+}
+
+// serveMux implements ServeMux, match registers the routes on the mux and
+// returns the members to serve.
+func serveMux(ctx context.Context, l net.Listener, match func(cmux.CMux) []ServableOnListener, shutdownTimeout time.Duration, opts []ServeOption) <-chan error {
+	if onServing := newServeOptions(opts).onServing; onServing != nil {
+		l = &onServingListener{Listener: l, onServing: onServing}
+		// Copied, appending must not alter the caller's slice.
+		opts = append(opts[:len(opts):len(opts)], WithOnServing(nil))
+	}
+
 	errs := make(chan error, 1)
 
 	go func() {
-		mux := cmux.New(l)
-		httpL := mux.Match(cmux.HTTP1Fast())
-		grpcL := mux.Match(cmux.Any())
-
 		defer close(errs)
+
+		mux := cmux.New(l)
 		defer mux.Close()
+		// The mux only returns once every accepted connection is matched, a
+		// client connecting without sending a byte, e.g. an idle pooled
+		// connection, would otherwise block the shutdown forever.
+		mux.SetReadTimeout(shutdownTimeout)
 
-		group, ctx := errgroup.WithContext(ctx)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
 
-		// Serve requests for the gRPC service.
-		group.Go(func() error {
-			if err := <-ServeWithGracefulShutdown(ctx, grpcL, server, shutdownTimeout); err != nil && !isClosedErr(err) {
-				return fmt.Errorf("Failed serving grpc: %w", err)
+		// Matchers must all be registered before the mux starts serving.
+		served := serveGroup(ctx, shutdownTimeout, match(mux), opts)
+
+		// Serve routing the listener, a failure shutdowns the routes.
+		muxErr := make(chan error, 1)
+		go func() {
+			defer close(muxErr)
+			if err := mux.Serve(); err != nil && !isClosedErr(err) {
+				muxErr <- fmt.Errorf("Failed serving mux: %w", err)
+				cancel()
 			}
+		}()
 
-			// When the grpc service shutdowns, it closes the net.Listener given by
-			// cmux which will also closes all net.Listener derived. Thus cascading
-			// other worker functions to exit.
-			return nil
-		})
+		err := <-served
 
-		// Serve requests for the prometheus HTTP metric handler.
-		group.Go(func() error {
-			httpServer := &http.Server{
-				Handler: handler,
-			}
-			if err := httpServer.Serve(httpL); err != nil && !isClosedErr(err) {
-				return fmt.Errorf("Failed serving http: %w", err)
-			}
-			return nil
-		})
+		// A shutdown usually closes the root listener as a side effect since the
+		// listeners derived by cmux share it. This is not required from a
+		// Servable, thus ensure the mux stops once every route is done.
+		_ = l.Close()
 
-		// Serve routing the listener
-		group.Go(func() error {
-			if err := mux.Serve(); err != nil && !isClosedErr(err) {
-				return fmt.Errorf("Failed serving mux: %w", err)
-			}
-			return nil
-		})
-
-		// The errgroup.Group will stop on 2 conditions:
-		//
-		// - When any error is encountered, this cascaded in a context cancellation
-		//   for the other workers.
-		//
-		// - When the grpc service gracefully shutdowns, it closes the listener
-		//   which will make the other workers to receive the ErrClosed error.
-		errs <- group.Wait()
+		errs <- perrors.NewErrors(err, <-muxErr)
 	}()
 
 	return errs
 }
 
+// ServeGRPCAndHTTP behaves like ServeWithGracefulShutdown excepts that it
+// also starts an HTTP1 service on the same Listener to expose
+// metrics.
+func ServeGRPCAndHTTP(ctx context.Context, l net.Listener, handler http.Handler, server *grpc.Server, shutdownTimeout time.Duration, opts ...ServeOption) <-chan error {
+	return serveMux(ctx, l, func(mux cmux.CMux) []ServableOnListener {
+		grpcL, httpL := matchGrpcHttp(mux)
+		return []ServableOnListener{
+			{Listener: httpL, Server: &http.Server{Handler: handler}},
+			{Listener: grpcL, Server: server},
+		}
+	}, shutdownTimeout, opts)
+}
+
+// matchGrpcHttp splits the connections of mux between gRPC and HTTP1, see
+// ServeGRPCAndHTTP and NewGrpcHttpMux.
+func matchGrpcHttp(mux cmux.CMux) (grpcL, httpL net.Listener) {
+	// Order matters, the catch-all matcher must come last.
+	httpL = mux.Match(cmux.HTTP1Fast())
+	grpcL = mux.Match(cmux.Any())
+	return grpcL, httpL
+}
+
+// NewGrpcHttpMux splits a Listener between gRPC and HTTP1 connections, with
+// the same routing as ServeGRPCAndHTTP. Connections are only dispatched once
+// serve is invoked, it blocks until the root Listener is closed and returns
+// nil in such case. This allows serving a custom http.Server or testing the
+// routing in isolation, ServeGRPCAndHTTP is preferred otherwise since it
+// handles the graceful shutdown.
+func NewGrpcHttpMux(l net.Listener) (grpcL, httpL net.Listener, serve func() error) {
+	mux := cmux.New(l)
+	grpcL, httpL = matchGrpcHttp(mux)
+
+	serve = func() error {
+		if err := mux.Serve(); err != nil && !isClosedErr(err) {
+			return err
+		}
+		return nil
+	}
+	return grpcL, httpL, serve
+}
+
 // ServeGRPCAndMetrics behaves like ServeWithGracefulShutdown excepts that it
 // also starts a prometheus HTTP1 service on the same Listener to expose
-// metrics.
-func ServeGRPCAndMetrics(ctx context.Context, l net.Listener, server *grpc.Server, shutdownTimeout time.Duration) <-chan error {
-	return ServeGRPCAndHTTP(ctx, l, promhttp.Handler(), server, shutdownTimeout)
+// metrics. The HTTP1 service also exposes `/healthz` and `/readyz` probes, the
+// latter failing once the shutdown begins. See NewMetricsMux, and WithGrpcWeb
+// to also serve gRPC-Web requests.
+func ServeGRPCAndMetrics(ctx context.Context, l net.Listener, server *grpc.Server, shutdownTimeout time.Duration, opts ...ServeOption) <-chan error {
+	readiness := NewReadiness()
+	opts = append([]ServeOption{WithReadiness(readiness)}, opts...)
+
+	var handler http.Handler = NewMetricsMux(readiness)
+	if webOpts := newServeOptions(opts).grpcWeb; webOpts != nil {
+		handler = NewGrpcWebHandler(server, handler, webOpts...)
+	}
+	return ServeGRPCAndHTTP(ctx, l, handler, server, shutdownTimeout, opts...)
+}
+
+// WaitAll waits for a value on each channel, e.g. returned by
+// ServeWithGracefulShutdown or ServeGRPCAndMetrics, and aggregates the
+// non-nil errors with errors.NewErrors. A closed channel counts as a nil
+// error. The channels are drained in order, thus WaitAll blocks until all of
+// them emitted.
+func WaitAll(chans ...<-chan error) error {
+	var errs []error
+	for _, ch := range chans {
+		errs = append(errs, <-ch)
+	}
+	return perrors.NewErrors(errs...)
+}
+
+// serverKind returns a human readable kind of Servable for logging purposes.
+func serverKind(server Servable) string {
+	switch server.(type) {
+	case *grpc.Server:
+		return "grpc"
+	case *http.Server:
+		return "http"
+	default:
+		return fmt.Sprintf("%T", server)
+	}
 }
 
 func isClosedErr(err error) bool {
-	return errors.Is(err, net.ErrClosed) || errors.Is(err, cmux.ErrServerClosed)
+	return errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, http.ErrServerClosed) ||
+		errors.Is(err, cmux.ErrServerClosed) ||
+		errors.Is(err, cmux.ErrListenerClosed)
 }
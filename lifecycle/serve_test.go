@@ -0,0 +1,483 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package lifecycle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/soheilhy/cmux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const testShutdownTimeout = 5 * time.Second
+
+func requireLocalListener(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return l
+}
+
+func newHealthGrpcServer() *grpc.Server {
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	return server
+}
+
+func assertHTTPServed(t *testing.T, addr string, expected string) {
+	resp, err := http.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(body))
+}
+
+func assertGrpcServed(t *testing.T, addr string) {
+	ctx, cancel := context.WithTimeout(context.Background(), testShutdownTimeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
+
+func helloHandler(msg string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(msg))
+	})
+}
+
+func TestServeMuxRoutesProtocols(t *testing.T) {
+	l := requireLocalListener(t)
+	addr := l.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	routes := []MuxRoute{
+		{Matcher: cmux.HTTP1Fast(), Server: &http.Server{Handler: helloHandler("hello")}},
+		{Matcher: cmux.Any(), Server: newHealthGrpcServer()},
+	}
+	errs := ServeMux(ctx, l, routes, testShutdownTimeout)
+
+	assertHTTPServed(t, addr, "hello")
+	assertGrpcServed(t, addr)
+
+	cancel()
+	assert.NoError(t, <-errs)
+
+	_, err := net.Dial("tcp", addr)
+	assert.Error(t, err, "listener should be closed after shutdown")
+}
+
+func TestServeGRPCAndHTTP(t *testing.T) {
+	l := requireLocalListener(t)
+	addr := l.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := ServeGRPCAndHTTP(ctx, l, helloHandler("metrics"), newHealthGrpcServer(), testShutdownTimeout)
+
+	assertHTTPServed(t, addr, "metrics")
+	assertGrpcServed(t, addr)
+
+	cancel()
+	assert.NoError(t, <-errs)
+}
+
+func TestServeGRPCAndHTTPWithSilentConnection(t *testing.T) {
+	l := requireLocalListener(t)
+	addr := l.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const shutdownTimeout = 100 * time.Millisecond
+	errs := ServeGRPCAndHTTP(ctx, l, helloHandler("metrics"), newHealthGrpcServer(), shutdownTimeout)
+
+	// Connections are accepted in order, the silent one is being matched once
+	// the request is served.
+	silent, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer silent.Close()
+	assertHTTPServed(t, addr, "metrics")
+
+	cancel()
+	select {
+	case err := <-errs:
+		assert.NoError(t, err)
+	case <-time.After(testShutdownTimeout):
+		t.Fatal("shutdown blocked by a connection never matched")
+	}
+}
+
+func TestNewGrpcHttpMux(t *testing.T) {
+	l := requireLocalListener(t)
+	addr := l.Addr().String()
+
+	grpcL, httpL, serve := NewGrpcHttpMux(l)
+	served := make(chan error, 1)
+	go func() { served <- serve() }()
+
+	grpcServer := newHealthGrpcServer()
+	go func() { _ = grpcServer.Serve(grpcL) }()
+	defer grpcServer.Stop()
+	httpServer := &http.Server{Handler: helloHandler("custom")}
+	go func() { _ = httpServer.Serve(httpL) }()
+	defer httpServer.Close()
+
+	assertHTTPServed(t, addr, "custom")
+	assertGrpcServed(t, addr)
+
+	require.NoError(t, l.Close())
+	assert.NoError(t, <-served)
+}
+
+// drainingServer waits for in-flight requests before shutting down the
+// http.Server, which would otherwise close the listener immediately.
+type drainingServer struct {
+	*http.Server
+	inflight *sync.WaitGroup
+}
+
+func (s *drainingServer) Shutdown(ctx context.Context) error {
+	s.inflight.Wait()
+	return s.Server.Shutdown(ctx)
+}
+
+func TestServeWithListenerCloseOnShutdown(t *testing.T) {
+	l := requireLocalListener(t)
+	addr := l.Addr().String()
+
+	var inflight sync.WaitGroup
+	entered, release := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		defer inflight.Done()
+		close(entered)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	})
+	inflight.Add(1)
+	server := &drainingServer{Server: &http.Server{Handler: handler}, inflight: &inflight}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := ServeWithGracefulShutdown(ctx, l, server, testShutdownTimeout, WithListenerCloseOnShutdown())
+
+	responses := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+
+	<-entered
+	cancel()
+
+	// New connections are refused while the request is in-flight.
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, time.Second, time.Millisecond)
+
+	close(release)
+	assert.Equal(t, "done", <-responses)
+	assert.NoError(t, <-errs)
+}
+
+func TestServeWithHealthServer(t *testing.T) {
+	l := requireLocalListener(t)
+
+	healthServer := health.NewServer()
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := ServeWithGracefulShutdown(ctx, l, server, testShutdownTimeout, WithHealthServer(healthServer))
+
+	assertGrpcServed(t, l.Addr().String())
+
+	cancel()
+	assert.NoError(t, <-errs)
+
+	resp, err := healthServer.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+}
+
+// failingServer fails to serve immediately.
+type failingServer struct {
+	err error
+}
+
+func (s failingServer) Serve(net.Listener) error {
+	return s.err
+}
+
+func TestServeGroup(t *testing.T) {
+	grpcL, httpL := requireLocalListener(t), requireLocalListener(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := ServeGroup(ctx, testShutdownTimeout,
+		ServableOnListener{Listener: grpcL, Server: newHealthGrpcServer()},
+		ServableOnListener{Listener: httpL, Server: &http.Server{Handler: helloHandler("admin")}},
+	)
+
+	assertGrpcServed(t, grpcL.Addr().String())
+	assertHTTPServed(t, httpL.Addr().String(), "admin")
+
+	cancel()
+	assert.NoError(t, <-errs)
+}
+
+func TestServeGroupFailureShutdownsMembers(t *testing.T) {
+	l := requireLocalListener(t)
+	errServe := errors.New("serve failed")
+
+	errs := ServeGroup(context.Background(), testShutdownTimeout,
+		ServableOnListener{Listener: l, Server: &http.Server{Handler: helloHandler("admin")}},
+		ServableOnListener{Listener: requireLocalListener(t), Server: failingServer{errServe}},
+	)
+
+	assert.ErrorIs(t, <-errs, errServe)
+
+	_, err := net.Dial("tcp", l.Addr().String())
+	assert.Error(t, err, "healthy member should be shutdown")
+}
+
+func TestServeWithGracefulShutdownLogsStartup(t *testing.T) {
+	l := requireLocalListener(t)
+	addr := l.Addr().String()
+
+	logs := new(bytes.Buffer)
+	logger := zerolog.New(&syncWriter{w: logs})
+
+	ctx, cancel := context.WithCancel(logger.WithContext(context.Background()))
+	defer cancel()
+	errs := ServeWithGracefulShutdown(ctx, l, newHealthGrpcServer(), testShutdownTimeout)
+	assertGrpcServed(t, addr)
+
+	cancel()
+	assert.NoError(t, <-errs)
+
+	assert.Contains(t, logs.String(), `"addr":"`+addr+`"`)
+	assert.Contains(t, logs.String(), `"server":"grpc"`)
+}
+
+// syncWriter serializes writes to an io.Writer.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func TestServeWithShutdownSignals(t *testing.T) {
+	l := requireLocalListener(t)
+	errs := ServeWithGracefulShutdown(context.Background(), l, newHealthGrpcServer(), testShutdownTimeout, WithShutdownSignals(syscall.SIGUSR1))
+	assertGrpcServed(t, l.Addr().String())
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	assert.NoError(t, <-errs)
+}
+
+func TestServeWithoutShutdownSignals(t *testing.T) {
+	l := requireLocalListener(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := ServeWithGracefulShutdown(ctx, l, newHealthGrpcServer(), testShutdownTimeout, WithShutdownSignals())
+	assertGrpcServed(t, l.Addr().String())
+
+	select {
+	case err := <-errs:
+		t.Fatalf("unexpected shutdown: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancel()
+	assert.NoError(t, <-errs)
+}
+
+func TestServeWithDrainDelay(t *testing.T) {
+	l := requireLocalListener(t)
+	addr := l.Addr().String()
+
+	healthServer := health.NewServer()
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	const drainDelay = 100 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := ServeWithGracefulShutdown(ctx, l, server, testShutdownTimeout, WithHealthServer(healthServer), WithDrainDelay(drainDelay))
+
+	start := time.Now()
+	cancel()
+
+	// Still serving, but reporting NOT_SERVING during the delay.
+	assert.Eventually(t, func() bool {
+		resp, err := healthServer.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		return err == nil && resp.Status == healthpb.HealthCheckResponse_NOT_SERVING
+	}, drainDelay, time.Millisecond)
+	conn, err := net.Dial("tcp", addr)
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+
+	assert.NoError(t, <-errs)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(drainDelay))
+}
+
+func TestServeWithGracefulShutdownResult(t *testing.T) {
+	t.Run("context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		results := ServeWithGracefulShutdownResult(ctx, requireLocalListener(t), newHealthGrpcServer(), testShutdownTimeout)
+		cancel()
+
+		result := <-results
+		assert.Equal(t, TriggeredByContext, result.TriggeredBy)
+		assert.NoError(t, result.ServeErr)
+		assert.NoError(t, result.ShutdownErr)
+		assert.NoError(t, result.Err())
+		assert.Nil(t, result.Signal)
+	})
+
+	t.Run("signal", func(t *testing.T) {
+		results := ServeWithGracefulShutdownResult(context.Background(), requireLocalListener(t), newHealthGrpcServer(), testShutdownTimeout, WithShutdownSignals(syscall.SIGUSR1))
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+
+		result := <-results
+		assert.Equal(t, TriggeredBySignal, result.TriggeredBy)
+		assert.Equal(t, syscall.SIGUSR1, result.Signal)
+		assert.NoError(t, result.Err())
+	})
+
+	t.Run("server", func(t *testing.T) {
+		errServe := errors.New("serve failed")
+		results := ServeWithGracefulShutdownResult(context.Background(), requireLocalListener(t), failingServer{errServe}, testShutdownTimeout)
+
+		result := <-results
+		assert.Equal(t, TriggeredByServer, result.TriggeredBy)
+		assert.ErrorIs(t, result.ServeErr, errServe)
+		assert.NoError(t, result.ShutdownErr)
+		assert.ErrorIs(t, result.Err(), errServe)
+	})
+}
+
+func TestServeWithOnServing(t *testing.T) {
+	l := requireLocalListener(t)
+	server := &http.Server{Handler: helloHandler("hello")}
+
+	var calls int
+	ready := make(chan struct{})
+	onServing := func() {
+		calls++
+		close(ready)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := ServeWithGracefulShutdown(ctx, l, server, testShutdownTimeout, WithOnServing(onServing), WithListenerCloseOnShutdown())
+
+	<-ready
+	assertHTTPServed(t, l.Addr().String(), "hello")
+	assertHTTPServed(t, l.Addr().String(), "hello")
+
+	cancel()
+	assert.NoError(t, <-errs)
+	assert.Equal(t, 1, calls)
+
+	// A server failing before accepting connections never serves.
+	errServe := errors.New("serve failed")
+	served := false
+	errs = ServeWithGracefulShutdown(context.Background(), requireLocalListener(t), failingServer{errServe}, testShutdownTimeout, WithOnServing(func() { served = true }))
+	assert.ErrorIs(t, <-errs, errServe)
+	assert.False(t, served)
+}
+
+func TestServeGRPCAndMetricsWithOnServing(t *testing.T) {
+	l := requireLocalListener(t)
+
+	var calls int32
+	ready := make(chan struct{})
+	onServing := func() {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(ready)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := ServeGRPCAndMetrics(ctx, l, newHealthGrpcServer(), testShutdownTimeout, WithOnServing(onServing))
+
+	<-ready
+	assertGrpcServed(t, l.Addr().String())
+	assertStatus(t, http.StatusOK, "http://"+l.Addr().String()+"/healthz")
+
+	cancel()
+	assert.NoError(t, <-errs)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "invoked once for all the routes")
+}
+
+func TestWaitAll(t *testing.T) {
+	emit := func(err error) <-chan error {
+		ch := make(chan error, 1)
+		ch <- err
+		close(ch)
+		return ch
+	}
+	closed := make(chan error)
+	close(closed)
+
+	assert.NoError(t, WaitAll())
+	assert.NoError(t, WaitAll(emit(nil), closed, emit(nil)))
+
+	errFirst, errSecond := errors.New("first"), errors.New("second")
+	err := WaitAll(emit(errFirst), emit(nil), closed, emit(errSecond))
+	assert.ErrorIs(t, err, errFirst)
+	assert.ErrorIs(t, err, errSecond)
+
+	l := requireLocalListener(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := ServeWithGracefulShutdown(ctx, l, newHealthGrpcServer(), testShutdownTimeout)
+	cancel()
+	assert.ErrorIs(t, WaitAll(served, emit(errFirst)), errFirst)
+}
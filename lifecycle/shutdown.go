@@ -4,7 +4,10 @@ package lifecycle
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 
+	perrors "github.com/optable/optable-pkglib/errors"
 	"google.golang.org/grpc"
 )
 
@@ -33,12 +36,18 @@ type (
 	}
 )
 
+// http.Server implements GracefulShutdown, thus it's handled by
+// MaybeGracefulShutdown without special-casing.
+var _ GracefulShutdown = (*http.Server)(nil)
+
 // MaybeGracefulShutdown takes an object and invokes Shutdown if the object
 // implements GracefulShutdown. This function exists to avoid forcing every
 // interface to also implement this.
 //
 // The function also knows how to handle graceful shutdown of grpc.Server
-// objects which exposes the GracefulStop/Stop methods.
+// objects which exposes the GracefulStop/Stop methods. http.Server objects
+// are shutdown with their Shutdown method, i.e. they stop accepting
+// connections and wait for the in-flight requests.
 //
 // This is often useful when builder functions, e.g. NewX() -> X returns
 // an interface where not all implementations implements GracefulShutdown.
@@ -55,6 +64,22 @@ func MaybeGracefulShutdown(ctx context.Context, i interface{}) error {
 	return ctx.Err()
 }
 
+// ShutdownSequence invokes MaybeGracefulShutdown on each component in order,
+// e.g. incoming servers first, then workers and finally storage flushes. A
+// failing component does not stop the sequence, every component gets a chance
+// to shutdown. Components share the context, thus each step gets the budget
+// left by the previous ones. The failures are aggregated with
+// errors.NewErrors, or nil if none.
+func ShutdownSequence(ctx context.Context, components ...interface{}) error {
+	var failures []error
+	for i, component := range components {
+		if err := MaybeGracefulShutdown(ctx, component); err != nil {
+			failures = append(failures, fmt.Errorf("Failed shutting down component %d (%T): %w", i, component, err))
+		}
+	}
+	return perrors.NewErrors(failures...)
+}
+
 // GracefulShutdownGrpcServer gracefully stops a grpc.Server by invoking first
 // the GracefulStop method, and then waiting for completion or until the cancel
 // timeouts; in such case the server is immediately shutdown in a non graceful
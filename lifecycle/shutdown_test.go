@@ -5,7 +5,11 @@ package lifecycle
 import (
 	"context"
 	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -52,3 +56,74 @@ func TestMaybeGracefullShutdown(t *testing.T) {
 	assert.ErrorIs(t, MaybeGracefulShutdown(ctx, basic), context.Canceled)
 	assert.ErrorIs(t, MaybeGracefulShutdown(ctx, aMap), context.Canceled)
 }
+
+func TestMaybeGracefulShutdownHTTPServer(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(entered)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	}))
+	defer server.Close()
+
+	responses := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+	<-entered
+
+	// The in-flight request exceeds the shutdown budget.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, MaybeGracefulShutdown(ctx, server.Config), context.DeadlineExceeded)
+
+	// The in-flight request completes within the shutdown budget.
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- MaybeGracefulShutdown(context.Background(), server.Config) }()
+	close(release)
+	assert.Equal(t, "done", <-responses)
+	assert.NoError(t, <-shutdown)
+
+	_, err := http.Get(server.URL)
+	assert.Error(t, err, "server should not accept connections once shutdown")
+}
+
+func TestShutdownSequence(t *testing.T) {
+	ctx := context.Background()
+
+	var order []int
+	step := func(i int, err error) ShutdownFn {
+		return func(context.Context) error {
+			order = append(order, i)
+			return err
+		}
+	}
+
+	assert.NoError(t, ShutdownSequence(ctx))
+	assert.NoError(t, ShutdownSequence(ctx, step(0, nil), struct{}{}, step(1, nil)))
+	assert.Equal(t, []int{0, 1}, order)
+
+	order = nil
+	err := ShutdownSequence(ctx, step(0, nil), step(1, errShutdown), step(2, nil))
+	assert.ErrorIs(t, err, errShutdown)
+	assert.Equal(t, []int{0, 1, 2}, order, "a failure must not stop the sequence")
+}
+
+func TestShutdownSequenceSharesBudget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	exhaust := ShutdownFn(func(context.Context) error {
+		cancel()
+		return nil
+	})
+
+	err := ShutdownSequence(ctx, exhaust, basic)
+	assert.ErrorIs(t, err, context.Canceled)
+}
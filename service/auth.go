@@ -0,0 +1,62 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package service
+
+import (
+	"context"
+
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthenticateFunc authenticates a RPC from its incoming metadata, e.g. by
+// verifying a bearer token in the `authorization` key. The returned context
+// is passed to the handler, e.g. to carry the authenticated identity.
+type AuthenticateFunc func(ctx context.Context, md metadata.MD) (context.Context, error)
+
+// authenticate invokes fn, failures are reported as Unauthenticated unless fn
+// returns a grpc status error, e.g. PermissionDenied.
+func authenticate(ctx context.Context, fn AuthenticateFunc) (context.Context, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		md = metadata.MD{}
+	}
+
+	ctx, err := fn(ctx, md)
+	if err == nil {
+		return ctx, nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return nil, err
+	}
+	return nil, status.Error(codes.Unauthenticated, err.Error())
+}
+
+// NewAuthUnaryInterceptor authenticates the unary RPCs with fn before invoking
+// the handler with the context returned by fn.
+func NewAuthUnaryInterceptor(fn AuthenticateFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, fn)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// NewAuthStreamInterceptor is the streaming counterpart of
+// NewAuthUnaryInterceptor.
+func NewAuthStreamInterceptor(fn AuthenticateFunc) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), fn)
+		if err != nil {
+			return err
+		}
+		wrapped := middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type identityKey struct{}
+
+// tokenAuth accepts the `secret` token and rejects `forbidden` with
+// PermissionDenied.
+func tokenAuth(ctx context.Context, md metadata.MD) (context.Context, error) {
+	tokens := md.Get("authorization")
+	switch {
+	case len(tokens) == 0:
+		return nil, errors.New("missing token")
+	case tokens[0] == "forbidden":
+		return nil, status.Error(codes.PermissionDenied, "forbidden")
+	case tokens[0] != "secret":
+		return nil, errors.New("invalid token")
+	}
+	return context.WithValue(ctx, identityKey{}, "alice"), nil
+}
+
+// identityHealthServer fails unless the caller is authenticated.
+type identityHealthServer struct {
+	healthpb.UnimplementedHealthServer
+}
+
+func (identityHealthServer) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if ctx.Value(identityKey{}) != "alice" {
+		return nil, status.Error(codes.Internal, "missing identity")
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func (identityHealthServer) Watch(_ *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	if stream.Context().Value(identityKey{}) != "alice" {
+		return status.Error(codes.Internal, "missing identity")
+	}
+	return stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+}
+
+func TestAuthInterceptors(t *testing.T) {
+	useTestRegistry(t)
+	server, err := NewGRPCService(context.Background(), identityHealthServer{}, WithDescriptors(&healthpb.Health_ServiceDesc),
+		[]grpc.UnaryServerInterceptor{NewAuthUnaryInterceptor(tokenAuth)},
+		[]grpc.StreamServerInterceptor{NewAuthStreamInterceptor(tokenAuth)})
+	require.NoError(t, err)
+	client := requireHealthClient(t, server)
+
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", token)
+	}
+
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.Check(withToken("wrong"), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.Check(withToken("forbidden"), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.Check(withToken("secret"), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+
+	watch, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = watch.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	watch, err = client.Watch(withToken("secret"), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = watch.Recv()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,26 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterCollector registers a prometheus.Collector, e.g. a service
+// implementing it, on the given registry. It is idempotent: registering a
+// collector already registered, see prometheus.AlreadyRegisteredError, is not
+// an error. This allows registering the collector of a service built with
+// WithoutCollectorRegistration once the registry is ready.
+func RegisterCollector(registry prometheus.Registerer, collector prometheus.Collector) error {
+	if err := registry.Register(collector); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			return nil
+		}
+		return fmt.Errorf("Failed registering metrics: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DeadlineMetrics observes the deadline budget left to handlers when they are
+// invoked. This helps detecting clients with overly tight timeouts. RPCs
+// without a deadline are counted separately since they have no budget.
+type DeadlineMetrics struct {
+	remaining       *prometheus.HistogramVec
+	withoutDeadline *prometheus.CounterVec
+}
+
+// NewDeadlineMetrics creates DeadlineMetrics. The returned value is a
+// prometheus.Collector which must be registered.
+func NewDeadlineMetrics() *DeadlineMetrics {
+	labels := []string{"grpc_service", "grpc_method"}
+	return &DeadlineMetrics{
+		remaining: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_deadline_remaining_seconds",
+			Help:    "Time left until the deadline of the RPC when the handler is invoked.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, labels),
+		withoutDeadline: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_without_deadline_total",
+			Help: "Total number of RPCs received without a deadline.",
+		}, labels),
+	}
+}
+
+func (m *DeadlineMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.remaining.Describe(ch)
+	m.withoutDeadline.Describe(ch)
+}
+
+func (m *DeadlineMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.remaining.Collect(ch)
+	m.withoutDeadline.Collect(ch)
+}
+
+func (m *DeadlineMetrics) observe(ctx context.Context, fullMethod string) {
+	service, method := splitFullMethod(fullMethod)
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		m.withoutDeadline.WithLabelValues(service, method).Inc()
+		return
+	}
+
+	m.remaining.WithLabelValues(service, method).Observe(time.Until(deadline).Seconds())
+}
+
+// UnaryServerInterceptor observes the deadline budget of unary RPCs.
+func (m *DeadlineMetrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		m.observe(ctx, info.FullMethod)
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor observes the deadline budget of streaming RPCs.
+func (m *DeadlineMetrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		m.observe(ss.Context(), info.FullMethod)
+		return handler(srv, ss)
+	}
+}
+
+// NewTimeoutUnaryInterceptor applies a deadline of d to the unary RPCs
+// received without one, such that clients omitting deadlines can't hold
+// handlers forever. The handler's context is cancelled once the deadline
+// elapses and the RPC fails with DeadlineExceeded, thus handlers must respect
+// their context to be interrupted. RPCs with a deadline are left untouched.
+func NewTimeoutUnaryInterceptor(d time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, ok := ctx.Deadline(); ok {
+			return handler(ctx, req)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+
+		resp, err := handler(ctx, req)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, status.Errorf(codes.DeadlineExceeded, "%s exceeded the default timeout of %s", info.FullMethod, d)
+		}
+		return resp, err
+	}
+}
+
+// splitFullMethod splits a `/package.service/method` string.
+func splitFullMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.Index(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", "unknown"
+}
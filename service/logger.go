@@ -0,0 +1,69 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// RequestIDMetadataKey is the incoming metadata key holding the request ID
+// propagated by clients. A request ID is generated when missing.
+const RequestIDMetadataKey = "x-request-id"
+
+// requestLogger returns the context of a RPC carrying a child of logger
+// enriched with the RPC's service, method, request ID and peer address.
+func requestLogger(ctx context.Context, logger zerolog.Logger, fullMethod string) context.Context {
+	service, method := splitFullMethod(fullMethod)
+	fields := logger.With().
+		Str("grpc.service", service).
+		Str("grpc.method", method).
+		Str("request_id", requestID(ctx))
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = fields.Str("peer.address", p.Addr.String())
+	}
+	scoped := fields.Logger()
+	return scoped.WithContext(ctx)
+}
+
+// requestID returns the request ID propagated by the client, or a random one.
+func requestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDMetadataKey); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
+
+// LoggerUnaryServerInterceptor injects a request scoped child of logger in
+// the context of unary RPCs, such that zerolog.Ctx(ctx) in handlers carries
+// the service, method, request ID and peer address. NewGRPCService includes
+// it by default with the logger of its context.
+func LoggerUnaryServerInterceptor(logger zerolog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(requestLogger(ctx, logger, info.FullMethod), req)
+	}
+}
+
+// LoggerStreamServerInterceptor is the streaming counterpart of
+// LoggerUnaryServerInterceptor.
+func LoggerStreamServerInterceptor(logger zerolog.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = requestLogger(ss.Context(), logger, info.FullMethod)
+		return handler(srv, wrapped)
+	}
+}
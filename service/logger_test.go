@@ -0,0 +1,109 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// loggingHealthServer logs with the context's logger on every Check.
+type loggingHealthServer struct {
+	healthpb.UnimplementedHealthServer
+}
+
+func (loggingHealthServer) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	zerolog.Ctx(ctx).Info().Msg("handled")
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+// handledLogs returns the fields of the "handled" log lines.
+func handledLogs(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var logs []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &fields))
+		if fields["message"] == "handled" {
+			logs = append(logs, fields)
+		}
+	}
+	return logs
+}
+
+func TestLoggerUnaryServerInterceptor(t *testing.T) {
+	buf := new(bytes.Buffer)
+	interceptor := LoggerUnaryServerInterceptor(zerolog.New(buf))
+	info := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return loggingHealthServer{}.Check(ctx, nil)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDMetadataKey, "abc"))
+	_, err := interceptor(ctx, nil, info, handler)
+	require.NoError(t, err)
+	_, err = interceptor(context.Background(), nil, info, handler)
+	require.NoError(t, err)
+
+	logs := handledLogs(t, buf)
+	require.Len(t, logs, 2)
+	assert.Equal(t, "grpc.health.v1.Health", logs[0]["grpc.service"])
+	assert.Equal(t, "Check", logs[0]["grpc.method"])
+	assert.Equal(t, "abc", logs[0]["request_id"], "the client's request ID is propagated")
+	assert.Len(t, logs[1]["request_id"], 32, "a request ID is generated when missing")
+}
+
+func TestNewGRPCServiceRequestLogger(t *testing.T) {
+	useTestRegistry(t)
+	buf := new(bytes.Buffer)
+	logger := zerolog.New(buf)
+	ctx := logger.WithContext(context.Background())
+	server, err := NewGRPCService(ctx, loggingHealthServer{}, WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil)
+	require.NoError(t, err)
+
+	client := requireHealthClient(t, server)
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	logs := handledLogs(t, buf)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "Check", logs[0]["grpc.method"])
+	assert.NotEmpty(t, logs[0]["request_id"])
+	assert.Contains(t, logs[0], "peer.address")
+}
+
+func TestNewGRPCServiceWithoutLogging(t *testing.T) {
+	callLogs := func(opts ...ServiceOption) (calls int, handled []map[string]interface{}) {
+		useTestRegistry(t)
+		buf := new(bytes.Buffer)
+		logger := zerolog.New(buf)
+		ctx := logger.WithContext(context.Background())
+		server, err := NewGRPCService(ctx, loggingHealthServer{}, WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil, opts...)
+		require.NoError(t, err)
+
+		client := requireHealthClient(t, server)
+		_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		server.GracefulStop()
+
+		return strings.Count(buf.String(), `"grpc.code"`), handledLogs(t, buf)
+	}
+
+	calls, handled := callLogs()
+	assert.NotZero(t, calls)
+	assert.Len(t, handled, 1)
+
+	calls, handled = callLogs(WithoutLogging())
+	assert.Zero(t, calls)
+	require.Len(t, handled, 1)
+	assert.Equal(t, "Check", handled[0]["grpc.method"], "handlers keep the request scoped logger")
+}
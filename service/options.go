@@ -0,0 +1,192 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package service
+
+import (
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/keepalive"
+)
+
+type (
+	// ServiceOption customizes the grpc.Server built by NewGRPCService.
+	ServiceOption interface {
+		apply(opts *serviceOptions)
+	}
+
+	serviceOptions struct {
+		health          *health.Server
+		reflection      bool
+		deadlineMetrics bool
+		panicMetrics    bool
+		defaultTimeout  time.Duration
+		outerUnary      []grpc.UnaryServerInterceptor
+		outerStream     []grpc.StreamServerInterceptor
+		serverOptions   []grpc.ServerOption
+		withoutRecovery bool
+		withoutLogging  bool
+		withoutMetrics  bool
+		recovery        []recovery.Option
+
+		withoutCollectorRegistration bool
+	}
+
+	serviceOptionFn func(opts *serviceOptions)
+)
+
+func (fn serviceOptionFn) apply(opts *serviceOptions) {
+	fn(opts)
+}
+
+func newServiceOptions(opts []ServiceOption) *serviceOptions {
+	options := &serviceOptions{}
+	for _, opt := range opts {
+		opt.apply(options)
+	}
+	return options
+}
+
+// WithHealthServer registers the standard grpc.health.v1 service backed by
+// the given health.Server. The caller keeps the handle to flip the serving
+// status, see also lifecycle.WithHealthServer to report NOT_SERVING during the
+// graceful shutdown.
+func WithHealthServer(h *health.Server) ServiceOption {
+	return serviceOptionFn(func(opts *serviceOptions) {
+		opts.health = h
+	})
+}
+
+// WithReflection registers the gRPC server reflection service, e.g. for
+// debugging with grpcurl. It is disabled by default since it exposes the
+// schema of every registered service.
+func WithReflection() ServiceOption {
+	return serviceOptionFn(func(opts *serviceOptions) {
+		opts.reflection = true
+	})
+}
+
+// WithDeadlineMetrics observes the deadline budget left to handlers, see
+// DeadlineMetrics. The metrics are registered along the default metrics.
+func WithDeadlineMetrics() ServiceOption {
+	return serviceOptionFn(func(opts *serviceOptions) {
+		opts.deadlineMetrics = true
+	})
+}
+
+// WithDefaultTimeout applies a deadline of d to the unary RPCs received
+// without one, see NewTimeoutUnaryInterceptor.
+func WithDefaultTimeout(d time.Duration) ServiceOption {
+	return serviceOptionFn(func(opts *serviceOptions) {
+		opts.defaultTimeout = d
+	})
+}
+
+// WithPanicMetrics counts and logs the panics recovered from handlers, see
+// PanicMetrics. The metrics are registered along the default metrics. A
+// recovery handler passed with WithRecoveryOptions takes precedence, and
+// WithoutRecovery disables it.
+func WithPanicMetrics() ServiceOption {
+	return serviceOptionFn(func(opts *serviceOptions) {
+		opts.panicMetrics = true
+	})
+}
+
+// WithOuterInterceptors prepends interceptors to the default chain, unlike
+// the interceptors arguments of NewGRPCService which are appended to it. The
+// default interceptors and the handlers then observe the context they set up,
+// e.g. a tracing span, see the tracing sub-package. A nil interceptor is
+// ignored.
+func WithOuterInterceptors(unary grpc.UnaryServerInterceptor, stream grpc.StreamServerInterceptor) ServiceOption {
+	return serviceOptionFn(func(opts *serviceOptions) {
+		if unary != nil {
+			opts.outerUnary = append(opts.outerUnary, unary)
+		}
+		if stream != nil {
+			opts.outerStream = append(opts.outerStream, stream)
+		}
+	})
+}
+
+// WithServerOptions passes additional options to grpc.NewServer. The default
+// interceptors chain is kept, use the interceptors arguments of
+// NewGRPCService to extend it.
+func WithServerOptions(serverOpts ...grpc.ServerOption) ServiceOption {
+	return serviceOptionFn(func(opts *serviceOptions) {
+		opts.serverOptions = append(opts.serverOptions, serverOpts...)
+	})
+}
+
+// WithTransportCredentials sets the transport credentials of the server, e.g.
+// to terminate (m)TLS in-process. This is a shorthand for
+// WithServerOptions(grpc.Creds(creds)).
+func WithTransportCredentials(creds credentials.TransportCredentials) ServiceOption {
+	return WithServerOptions(grpc.Creds(creds))
+}
+
+// WithMaxMsgSize sets the maximum size in bytes of received and sent
+// messages. A zero size keeps the grpc default, i.e. 4MiB for received
+// messages and unlimited for sent messages.
+func WithMaxMsgSize(recv, send int) ServiceOption {
+	var serverOpts []grpc.ServerOption
+	if recv > 0 {
+		serverOpts = append(serverOpts, grpc.MaxRecvMsgSize(recv))
+	}
+	if send > 0 {
+		serverOpts = append(serverOpts, grpc.MaxSendMsgSize(send))
+	}
+	return WithServerOptions(serverOpts...)
+}
+
+// WithKeepalive sets the keepalive parameters of the server and the policy
+// enforced on clients' keepalive pings, e.g. to avoid killing long-lived
+// streams.
+func WithKeepalive(params keepalive.ServerParameters, policy keepalive.EnforcementPolicy) ServiceOption {
+	return WithServerOptions(grpc.KeepaliveParams(params), grpc.KeepaliveEnforcementPolicy(policy))
+}
+
+// WithRecoveryOptions customizes the default recovery interceptors, e.g. with
+// recovery.WithRecoveryHandlerContext to log and count panics.
+func WithRecoveryOptions(recoveryOpts ...recovery.Option) ServiceOption {
+	return serviceOptionFn(func(opts *serviceOptions) {
+		opts.recovery = append(opts.recovery, recoveryOpts...)
+	})
+}
+
+// WithoutRecovery disables the default recovery interceptors. A panicking
+// handler then crashes the process.
+func WithoutRecovery() ServiceOption {
+	return serviceOptionFn(func(opts *serviceOptions) {
+		opts.withoutRecovery = true
+	})
+}
+
+// WithoutLogging disables the default logging interceptors logging every RPC,
+// e.g. when a service mesh already does. Handlers still get a request scoped
+// logger, see LoggerUnaryServerInterceptor.
+func WithoutLogging() ServiceOption {
+	return serviceOptionFn(func(opts *serviceOptions) {
+		opts.withoutLogging = true
+	})
+}
+
+// WithoutMetrics disables the default metrics interceptors, the
+// metrics.ServerMetrics are then neither created nor registered. The
+// opt-in metrics, e.g. WithDeadlineMetrics, are unaffected.
+func WithoutMetrics() ServiceOption {
+	return serviceOptionFn(func(opts *serviceOptions) {
+		opts.withoutMetrics = true
+	})
+}
+
+// WithoutCollectorRegistration skips the registration of the service on
+// prometheus.DefaultRegisterer when it implements prometheus.Collector. Use
+// RegisterCollector to register it later, e.g. once the registry is set up.
+func WithoutCollectorRegistration() ServiceOption {
+	return serviceOptionFn(func(opts *serviceOptions) {
+		opts.withoutCollectorRegistration = true
+	})
+}
@@ -0,0 +1,61 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package service
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PanicMetrics counts the panics recovered from handlers. This helps detecting
+// regressions manifesting as panics, which are otherwise only reported as
+// Internal errors to clients.
+type PanicMetrics struct {
+	panics *prometheus.CounterVec
+}
+
+// NewPanicMetrics creates PanicMetrics. The returned value is a
+// prometheus.Collector which must be registered.
+func NewPanicMetrics() *PanicMetrics {
+	return &PanicMetrics{
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_panics_total",
+			Help: "Total number of panics recovered from handlers.",
+		}, []string{"grpc_service", "grpc_method"}),
+	}
+}
+
+func (m *PanicMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.panics.Describe(ch)
+}
+
+func (m *PanicMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.panics.Collect(ch)
+}
+
+// RecoveryHandler returns a recovery handler, see
+// recovery.WithRecoveryHandlerContext, counting the panic and logging the
+// recovered value and the stack with the context's logger. The RPC fails with
+// an Internal error without leaking the recovered value to the client.
+func (m *PanicMetrics) RecoveryHandler() recovery.RecoveryHandlerFuncContext {
+	return func(ctx context.Context, p interface{}) error {
+		fullMethod, _ := grpc.Method(ctx)
+		service, method := splitFullMethod(fullMethod)
+		m.panics.WithLabelValues(service, method).Inc()
+
+		zerolog.Ctx(ctx).Error().
+			Str("panic", fmt.Sprint(p)).
+			Bytes("stack", debug.Stack()).
+			Msg("Recovered from panic in handler")
+
+		return status.Error(codes.Internal, "internal error")
+	}
+}
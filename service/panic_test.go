@@ -0,0 +1,69 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewGRPCServicePanicMetrics(t *testing.T) {
+	registry := useTestRegistry(t)
+	buf := new(bytes.Buffer)
+	logger := zerolog.New(buf)
+	ctx := logger.WithContext(context.Background())
+	server, err := NewGRPCService(ctx, panicHealthServer{}, WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil, WithPanicMetrics())
+	require.NoError(t, err)
+
+	client := requireHealthClient(t, server)
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.NotContains(t, status.Convert(err).Message(), "boom")
+
+	expected := `
+# HELP grpc_panics_total Total number of panics recovered from handlers.
+# TYPE grpc_panics_total counter
+grpc_panics_total{grpc_method="Check",grpc_service="grpc.health.v1.Health"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "grpc_panics_total"))
+
+	var panicLog map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &fields))
+		if fields["message"] == "Recovered from panic in handler" {
+			panicLog = fields
+		}
+	}
+	require.NotNil(t, panicLog)
+	assert.Equal(t, "boom", panicLog["panic"])
+	assert.NotEmpty(t, panicLog["stack"])
+	assert.Equal(t, "Check", panicLog["grpc.method"], "logged with the request scoped logger")
+}
+
+func TestNewGRPCServicePanicMetricsHandlerPrecedence(t *testing.T) {
+	useTestRegistry(t)
+
+	handler := func(ctx context.Context, p interface{}) error {
+		return status.Error(codes.Aborted, "recovered")
+	}
+	server, err := NewGRPCService(context.Background(), panicHealthServer{}, WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil,
+		WithPanicMetrics(), WithRecoveryOptions(recovery.WithRecoveryHandlerContext(handler)))
+	require.NoError(t, err)
+
+	client := requireHealthClient(t, server)
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+}
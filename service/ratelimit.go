@@ -0,0 +1,41 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package service
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Limiter decides whether a request is allowed, e.g. a
+// golang.org/x/time/rate.Limiter.
+type Limiter interface {
+	Allow() bool
+}
+
+// RateLimitUnaryInterceptor rejects the unary RPCs with ResourceExhausted when
+// the limiter doesn't allow them. The limiter is shared by all the methods,
+// see RateLimitUnaryInterceptorByMethod to limit methods individually.
+func RateLimitUnaryInterceptor(limiter Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !limiter.Allow() {
+			return nil, status.Errorf(codes.ResourceExhausted, "%s is rate limited, retry later", info.FullMethod)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// RateLimitUnaryInterceptorByMethod behaves like RateLimitUnaryInterceptor
+// with a limiter per method, keyed by full method name, e.g.
+// `/package.service/method`. Methods without a limiter are not limited.
+func RateLimitUnaryInterceptorByMethod(limiters map[string]Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if limiter, ok := limiters[info.FullMethod]; ok && !limiter.Allow() {
+			return nil, status.Errorf(codes.ResourceExhausted, "%s is rate limited, retry later", info.FullMethod)
+		}
+		return handler(ctx, req)
+	}
+}
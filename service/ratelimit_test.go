@@ -0,0 +1,61 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// budgetLimiter allows a fixed number of requests.
+type budgetLimiter struct {
+	budget int
+}
+
+func (l *budgetLimiter) Allow() bool {
+	if l.budget == 0 {
+		return false
+	}
+	l.budget--
+	return true
+}
+
+func TestRateLimitUnaryInterceptor(t *testing.T) {
+	useTestRegistry(t)
+	server, err := NewGRPCService(context.Background(), health.NewServer(), WithDescriptors(&healthpb.Health_ServiceDesc),
+		[]grpc.UnaryServerInterceptor{RateLimitUnaryInterceptor(&budgetLimiter{budget: 2})}, nil)
+	require.NoError(t, err)
+
+	client := requireHealthClient(t, server)
+	for i := 0; i < 2; i++ {
+		_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+	}
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestRateLimitUnaryInterceptorByMethod(t *testing.T) {
+	interceptor := RateLimitUnaryInterceptorByMethod(map[string]Limiter{
+		"/svc/Expensive": &budgetLimiter{budget: 1},
+	})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	call := func(method string) error {
+		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	assert.NoError(t, call("/svc/Expensive"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call("/svc/Expensive")))
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, call("/svc/Cheap"), "methods without a limiter are not limited")
+	}
+}
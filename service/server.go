@@ -12,55 +12,126 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 // NewGRPCService creates a grpc service with various defaults middlewares.
 // Notably, the logging and metrics are automatically registered for sane
 // defaults of observability.
-func NewGRPCService(ctx context.Context, service interface{}, descriptors []*grpc.ServiceDesc, unaryIntercepts []grpc.UnaryServerInterceptor, streamIntercepts []grpc.StreamServerInterceptor) (*grpc.Server, error) {
+func NewGRPCService(ctx context.Context, service interface{}, descriptors []*grpc.ServiceDesc, unaryIntercepts []grpc.UnaryServerInterceptor, streamIntercepts []grpc.StreamServerInterceptor, opts ...ServiceOption) (*grpc.Server, error) {
+	server, _, err := NewGRPCServiceWithMetrics(ctx, service, descriptors, unaryIntercepts, streamIntercepts, opts...)
+	return server, err
+}
+
+// NewGRPCServiceWithMetrics behaves like NewGRPCService and also returns the
+// metrics.ServerMetrics observing the RPCs, e.g. to inspect the handling time
+// histograms in tests. The metrics are registered on
+// prometheus.DefaultRegisterer, where custom metrics should be registered too.
+// The returned metrics are nil with WithoutMetrics.
+func NewGRPCServiceWithMetrics(ctx context.Context, service interface{}, descriptors []*grpc.ServiceDesc, unaryIntercepts []grpc.UnaryServerInterceptor, streamIntercepts []grpc.StreamServerInterceptor, opts ...ServiceOption) (*grpc.Server, *metrics.ServerMetrics, error) {
 	if len(descriptors) == 0 {
-		return nil, errors.New("Missing descriptors")
+		return nil, nil, errors.New("Missing descriptors")
 	}
+	options := newServiceOptions(opts)
+
 	// By using prometheus.DefaultRegister we benefits from the go runtime
 	// defaults metrics and Linux processes metrics.
 	registry := prometheus.DefaultRegisterer
 
-	m := metrics.NewRegisteredServerMetrics(registry, metrics.WithServerHandlingTimeHistogram())
-	if collector, ok := service.(prometheus.Collector); ok {
-		if err := registry.Register(collector); err != nil {
-			return nil, fmt.Errorf("Failed registering metrics: %w", err)
+	var m *metrics.ServerMetrics
+	if !options.withoutMetrics {
+		m = metrics.NewRegisteredServerMetrics(registry, metrics.WithServerHandlingTimeHistogram())
+	}
+	if collector, ok := service.(prometheus.Collector); ok && !options.withoutCollectorRegistration {
+		if err := RegisterCollector(registry, collector); err != nil {
+			return nil, nil, err
 		}
 	}
 
 	logger := zerolog.Ctx(ctx)
 	defaultStreamInterceptors := []grpc.StreamServerInterceptor{
-		logging.StreamServerInterceptor(grpczerolog.InterceptorLogger(*logger)),
-		metrics.StreamServerInterceptor(m),
-		recovery.StreamServerInterceptor(),
+		LoggerStreamServerInterceptor(*logger),
 	}
 	defaultUnaryInterceptors := []grpc.UnaryServerInterceptor{
-		logging.UnaryServerInterceptor(grpczerolog.InterceptorLogger(*logger)),
-		metrics.UnaryServerInterceptor(m),
-		recovery.UnaryServerInterceptor(),
+		LoggerUnaryServerInterceptor(*logger),
+	}
+
+	if !options.withoutLogging {
+		defaultStreamInterceptors = append(defaultStreamInterceptors, logging.StreamServerInterceptor(grpczerolog.InterceptorLogger(*logger)))
+		defaultUnaryInterceptors = append(defaultUnaryInterceptors, logging.UnaryServerInterceptor(grpczerolog.InterceptorLogger(*logger)))
+	}
+
+	if m != nil {
+		defaultStreamInterceptors = append(defaultStreamInterceptors, metrics.StreamServerInterceptor(m))
+		defaultUnaryInterceptors = append(defaultUnaryInterceptors, metrics.UnaryServerInterceptor(m))
+	}
+
+	if !options.withoutRecovery {
+		recoveryOpts := options.recovery
+		if options.panicMetrics {
+			panics := NewPanicMetrics()
+			if err := registry.Register(panics); err != nil {
+				return nil, nil, fmt.Errorf("Failed registering panic metrics: %w", err)
+			}
+			// Prepended such that a handler of WithRecoveryOptions takes precedence.
+			recoveryOpts = append([]recovery.Option{recovery.WithRecoveryHandlerContext(panics.RecoveryHandler())}, recoveryOpts...)
+		}
+		defaultStreamInterceptors = append(defaultStreamInterceptors, recovery.StreamServerInterceptor(recoveryOpts...))
+		defaultUnaryInterceptors = append(defaultUnaryInterceptors, recovery.UnaryServerInterceptor(recoveryOpts...))
 	}
 
+	if options.deadlineMetrics {
+		deadlines := NewDeadlineMetrics()
+		if err := registry.Register(deadlines); err != nil {
+			return nil, nil, fmt.Errorf("Failed registering deadline metrics: %w", err)
+		}
+		defaultStreamInterceptors = append(defaultStreamInterceptors, deadlines.StreamServerInterceptor())
+		defaultUnaryInterceptors = append(defaultUnaryInterceptors, deadlines.UnaryServerInterceptor())
+	}
+
+	// After the deadline metrics such that they observe the clients' deadlines.
+	if options.defaultTimeout > 0 {
+		defaultUnaryInterceptors = append(defaultUnaryInterceptors, NewTimeoutUnaryInterceptor(options.defaultTimeout))
+	}
+
+	defaultStreamInterceptors = append(options.outerStream, defaultStreamInterceptors...)
+	defaultUnaryInterceptors = append(options.outerUnary, defaultUnaryInterceptors...)
+
 	defaultUnaryInterceptors = append(defaultUnaryInterceptors, unaryIntercepts...)
 	defaultStreamInterceptors = append(defaultStreamInterceptors, streamIntercepts...)
 
-	server := grpc.NewServer(grpc.ChainStreamInterceptor(defaultStreamInterceptors...), grpc.ChainUnaryInterceptor(defaultUnaryInterceptors...))
+	serverOptions := append([]grpc.ServerOption{
+		grpc.ChainStreamInterceptor(defaultStreamInterceptors...),
+		grpc.ChainUnaryInterceptor(defaultUnaryInterceptors...),
+	}, options.serverOptions...)
+	server := grpc.NewServer(serverOptions...)
 
 	for _, desc := range descriptors {
 		logger.Info().Msgf("Registering grpc service: %s", desc.ServiceName)
 		server.RegisterService(desc, service)
 	}
 
+	if options.health != nil {
+		logger.Info().Msgf("Registering grpc service: %s", healthpb.Health_ServiceDesc.ServiceName)
+		healthpb.RegisterHealthServer(server, options.health)
+	}
+
+	// Reflection must be registered last to list all registered services.
+	if options.reflection {
+		logger.Info().Msg("Registering grpc reflection service")
+		reflection.Register(server)
+	}
+
 	// Ensure that all metrics for all endpoints are default to NULL instead of
 	// being lazily added to the metrics the first time an endpoint is hit.
 	//
 	// This must be called once all gRPC services are registered.
-	m.InitializeMetrics(server)
+	if m != nil {
+		m.InitializeMetrics(server)
+	}
 
-	return server, nil
+	return server, m, nil
 }
 
 func WithDescriptors(descs ...*grpc.ServiceDesc) []*grpc.ServiceDesc {
@@ -0,0 +1,464 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const reflectionServiceName = "grpc.reflection.v1alpha.ServerReflection"
+
+// NewGRPCService registers its metrics on prometheus.DefaultRegisterer which
+// fails on duplicates. Each test thus gets a fresh registry.
+func useTestRegistry(t *testing.T) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	previous := prometheus.DefaultRegisterer
+	prometheus.DefaultRegisterer = registry
+	t.Cleanup(func() { prometheus.DefaultRegisterer = previous })
+	return registry
+}
+
+func requireHealthService(t *testing.T, opts ...ServiceOption) *grpc.Server {
+	return requireHealthServiceWith(t, health.NewServer(), opts...)
+}
+
+func requireHealthServiceWith(t *testing.T, h healthpb.HealthServer, opts ...ServiceOption) *grpc.Server {
+	useTestRegistry(t)
+	server, err := NewGRPCService(context.Background(), h, WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil, opts...)
+	require.NoError(t, err)
+	return server
+}
+
+// panicHealthServer panics on every Check.
+type panicHealthServer struct {
+	healthpb.UnimplementedHealthServer
+}
+
+func (panicHealthServer) Check(context.Context, *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	panic("boom")
+}
+
+// requireHealthClient serves the server on an in-memory listener and returns a
+// health client connected to it. The dial options override the defaults, e.g.
+// the insecure transport credentials.
+func requireHealthClient(t *testing.T, server *grpc.Server, dialOpts ...grpc.DialOption) healthpb.HealthClient {
+	l := bufconn.Listen(1024 * 1024)
+	go func() { _ = server.Serve(l) }()
+	t.Cleanup(server.Stop)
+
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }
+	dialOpts = append([]grpc.DialOption{grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials())}, dialOpts...)
+	conn, err := grpc.Dial("bufconn", dialOpts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestNewGRPCServiceRequiresDescriptors(t *testing.T) {
+	useTestRegistry(t)
+	_, err := NewGRPCService(context.Background(), nil, nil, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestNewGRPCServiceWithMetrics(t *testing.T) {
+	registry := useTestRegistry(t)
+	server, m, err := NewGRPCServiceWithMetrics(context.Background(), health.NewServer(), WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil)
+	require.NoError(t, err)
+	require.NotNil(t, m)
+
+	client := requireHealthClient(t, server)
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	// The returned metrics are the ones registered.
+	assert.Equal(t, 2, testutil.CollectAndCount(m, "grpc_server_handling_seconds"))
+	assert.Equal(t, 1.0, handledTotal(t, registry))
+}
+
+// handledTotal sums the RPCs handled by the default metrics interceptors.
+func handledTotal(t *testing.T, registry *prometheus.Registry) float64 {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	handled := 0.0
+	for _, family := range families {
+		if family.GetName() != "grpc_server_handled_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			handled += metric.GetCounter().GetValue()
+		}
+	}
+	return handled
+}
+
+func TestNewGRPCServiceWithoutMetrics(t *testing.T) {
+	registry := useTestRegistry(t)
+	server, m, err := NewGRPCServiceWithMetrics(context.Background(), health.NewServer(), WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil, WithoutMetrics())
+	require.NoError(t, err)
+	assert.Nil(t, m)
+
+	client := requireHealthClient(t, server)
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	assert.Empty(t, families)
+}
+
+func TestNewGRPCServiceReflection(t *testing.T) {
+	server := requireHealthService(t)
+	assert.NotContains(t, server.GetServiceInfo(), reflectionServiceName)
+
+	server = requireHealthService(t, WithReflection())
+	assert.Contains(t, server.GetServiceInfo(), reflectionServiceName)
+	assert.Contains(t, server.GetServiceInfo(), healthpb.Health_ServiceDesc.ServiceName)
+}
+
+func TestDeadlineMetrics(t *testing.T) {
+	deadlines := NewDeadlineMetrics()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(deadlines)
+
+	interceptor := deadlines.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return req, nil }
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		_, err := interceptor(ctx, nil, info, handler)
+		assert.NoError(t, err)
+	}
+	_, err := interceptor(context.Background(), nil, info, handler)
+	assert.NoError(t, err)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		switch family.GetName() {
+		case "grpc_server_deadline_remaining_seconds":
+			require.Len(t, family.GetMetric(), 1)
+			assert.Equal(t, uint64(3), family.GetMetric()[0].GetHistogram().GetSampleCount())
+		case "grpc_server_without_deadline_total":
+			require.Len(t, family.GetMetric(), 1)
+			assert.Equal(t, float64(1), family.GetMetric()[0].GetCounter().GetValue())
+		}
+	}
+	assert.Equal(t, 2, testutil.CollectAndCount(deadlines))
+}
+
+func TestNewGRPCServiceDeadlineMetrics(t *testing.T) {
+	registry := useTestRegistry(t)
+	_, err := NewGRPCService(context.Background(), health.NewServer(), WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil, WithDeadlineMetrics())
+	require.NoError(t, err)
+
+	// The metrics are already registered by NewGRPCService.
+	err = registry.Register(NewDeadlineMetrics())
+	assert.IsType(t, prometheus.AlreadyRegisteredError{}, err)
+}
+
+// blockingHealthServer blocks every Check until its context is done.
+type blockingHealthServer struct {
+	healthpb.UnimplementedHealthServer
+}
+
+func (blockingHealthServer) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestNewTimeoutUnaryInterceptor(t *testing.T) {
+	interceptor := NewTimeoutUnaryInterceptor(10 * time.Millisecond)
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/Method"}
+	block := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return blockingHealthServer{}.Check(ctx, nil)
+	}
+
+	_, err := interceptor(context.Background(), nil, info, block)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	// The client's deadline is kept.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	expected, _ := ctx.Deadline()
+	resp, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		deadline, _ := ctx.Deadline()
+		return deadline, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, expected, resp)
+}
+
+func TestNewGRPCServiceDefaultTimeout(t *testing.T) {
+	useTestRegistry(t)
+	server, err := NewGRPCService(context.Background(), blockingHealthServer{}, WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil,
+		WithDefaultTimeout(10*time.Millisecond))
+	require.NoError(t, err)
+
+	client := requireHealthClient(t, server)
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestNewGRPCServiceRecoveryOptions(t *testing.T) {
+	useTestRegistry(t)
+
+	var recovered interface{}
+	handler := func(ctx context.Context, p interface{}) error {
+		recovered = p
+		return status.Error(codes.Aborted, "recovered")
+	}
+	server, err := NewGRPCService(context.Background(), panicHealthServer{}, WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil,
+		WithRecoveryOptions(recovery.WithRecoveryHandlerContext(handler)))
+	require.NoError(t, err)
+
+	client := requireHealthClient(t, server)
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Equal(t, "boom", recovered)
+}
+
+// collectorHealthServer is a health service exporting its own metrics.
+type collectorHealthServer struct {
+	*health.Server
+	prometheus.Counter
+}
+
+func newCollectorHealthServer() *collectorHealthServer {
+	return &collectorHealthServer{
+		Server:  health.NewServer(),
+		Counter: prometheus.NewCounter(prometheus.CounterOpts{Name: "test_health_checks_total"}),
+	}
+}
+
+func TestRegisterCollectorIsIdempotent(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	service := newCollectorHealthServer()
+
+	require.NoError(t, RegisterCollector(registry, service))
+	require.NoError(t, RegisterCollector(registry, service))
+
+	count, err := testutil.GatherAndCount(registry, "test_health_checks_total")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestNewGRPCServiceWithoutCollectorRegistration(t *testing.T) {
+	registry := useTestRegistry(t)
+	service := newCollectorHealthServer()
+
+	_, err := NewGRPCService(context.Background(), service, WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil, WithoutCollectorRegistration())
+	require.NoError(t, err)
+
+	count, err := testutil.GatherAndCount(registry, "test_health_checks_total")
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	require.NoError(t, RegisterCollector(registry, service))
+	count, err = testutil.GatherAndCount(registry, "test_health_checks_total")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+// selfSignedTLS returns the credentials of a server presenting a self-signed
+// certificate for `bufconn` and of a client trusting it.
+func selfSignedTLS(t *testing.T) (server, client credentials.TransportCredentials) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"bufconn"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = credentials.NewServerTLSFromCert(&tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key})
+	return server, credentials.NewClientTLSFromCert(pool, "bufconn")
+}
+
+func TestNewGRPCServiceTransportCredentials(t *testing.T) {
+	serverCreds, clientCreds := selfSignedTLS(t)
+
+	registry := useTestRegistry(t)
+	server, err := NewGRPCService(context.Background(), health.NewServer(), WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil,
+		WithTransportCredentials(serverCreds))
+	require.NoError(t, err)
+
+	client := requireHealthClient(t, server, grpc.WithTransportCredentials(clientCreds))
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, handledTotal(t, registry), "the default interceptors are kept")
+
+	// Plaintext clients are rejected.
+	useTestRegistry(t)
+	server, err = NewGRPCService(context.Background(), health.NewServer(), WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil,
+		WithServerOptions(grpc.Creds(serverCreds)))
+	require.NoError(t, err)
+
+	client = requireHealthClient(t, server)
+	_, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestNewGRPCServiceMaxMsgSize(t *testing.T) {
+	// Larger than the 4MiB grpc default.
+	name := strings.Repeat("a", 5<<20)
+	h := health.NewServer()
+	h.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+
+	server := requireHealthServiceWith(t, h)
+	_, err := requireHealthClient(t, server).Check(context.Background(), &healthpb.HealthCheckRequest{Service: name})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	server = requireHealthServiceWith(t, h, WithMaxMsgSize(8<<20, 0))
+	resp, err := requireHealthClient(t, server).Check(context.Background(), &healthpb.HealthCheckRequest{Service: name})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
+
+// pingServer sends `n` HTTP/2 pings in a row on conn, bypassing the minimum
+// interval enforced by grpc clients. It returns the number of acknowledged
+// pings and the GOAWAY frame received before all were acknowledged, if any.
+func pingServer(t *testing.T, conn net.Conn, n int) (int, *http2.GoAwayFrame) {
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err := conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+	framer := http2.NewFramer(conn, conn)
+	require.NoError(t, framer.WriteSettings())
+	for i := 0; i < n; i++ {
+		require.NoError(t, framer.WritePing(false, [8]byte{byte(i)}))
+	}
+
+	acks := 0
+	for acks < n {
+		frame, err := framer.ReadFrame()
+		require.NoError(t, err)
+		switch f := frame.(type) {
+		case *http2.PingFrame:
+			if f.IsAck() {
+				acks++
+			}
+		case *http2.GoAwayFrame:
+			return acks, f
+		}
+	}
+	return acks, nil
+}
+
+func TestNewGRPCServiceKeepalive(t *testing.T) {
+	for _, minTime := range []time.Duration{time.Nanosecond, time.Minute} {
+		registry := useTestRegistry(t)
+		server, err := NewGRPCService(context.Background(), health.NewServer(), WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil,
+			WithKeepalive(keepalive.ServerParameters{}, keepalive.EnforcementPolicy{MinTime: minTime, PermitWithoutStream: true}))
+		require.NoError(t, err)
+
+		_, err = requireHealthClient(t, server).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, handledTotal(t, registry), "the default interceptors are kept")
+
+		l := bufconn.Listen(1024 * 1024)
+		go func() { _ = server.Serve(l) }()
+		conn, err := l.Dial()
+		require.NoError(t, err)
+		defer conn.Close()
+
+		const pings = 5
+		acks, goAway := pingServer(t, conn, pings)
+		if minTime == time.Minute {
+			// Pinging more often than MinTime gets the client disconnected.
+			require.NotNil(t, goAway)
+			assert.Equal(t, http2.ErrCodeEnhanceYourCalm, goAway.ErrCode)
+			assert.Equal(t, "too_many_pings", string(goAway.DebugData()))
+		} else {
+			assert.Nil(t, goAway)
+			assert.Equal(t, pings, acks)
+		}
+	}
+}
+
+func TestNewGRPCServiceHealthServer(t *testing.T) {
+	// Any other service, the health service is registered by the option.
+	desc := &grpc.ServiceDesc{ServiceName: "test.Dummy", HandlerType: (*interface{})(nil)}
+
+	useTestRegistry(t)
+	server, err := NewGRPCService(context.Background(), struct{}{}, WithDescriptors(desc), nil, nil)
+	require.NoError(t, err)
+	assert.NotContains(t, server.GetServiceInfo(), healthpb.Health_ServiceDesc.ServiceName)
+
+	h := health.NewServer()
+	useTestRegistry(t)
+	server, err = NewGRPCService(context.Background(), struct{}{}, WithDescriptors(desc), nil, nil, WithHealthServer(h))
+	require.NoError(t, err)
+	assert.Contains(t, server.GetServiceInfo(), healthpb.Health_ServiceDesc.ServiceName)
+	assert.Contains(t, server.GetServiceInfo(), desc.ServiceName)
+
+	client := requireHealthClient(t, server)
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	h.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	resp, err = client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+}
+
+func TestNewGRPCServiceWithoutRecovery(t *testing.T) {
+	// The outermost interceptor observes the panics escaping the default
+	// chain, instead of crashing the test.
+	var escaped interface{}
+	outer := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				escaped = p
+				err = status.Error(codes.Aborted, "escaped")
+			}
+		}()
+		return handler(ctx, req)
+	}
+
+	server := requireHealthServiceWith(t, panicHealthServer{}, WithOuterInterceptors(outer, nil))
+	_, err := requireHealthClient(t, server).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	// The default recovery handler returns a recovery.PanicError, i.e. Unknown.
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Nil(t, escaped, "recovered by default")
+
+	server = requireHealthServiceWith(t, panicHealthServer{}, WithOuterInterceptors(outer, nil), WithoutRecovery())
+	_, err = requireHealthClient(t, server).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Equal(t, "boom", escaped)
+}
@@ -0,0 +1,26 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+
+// Package tracing traces the RPCs of service.NewGRPCService with
+// OpenTelemetry. It is kept apart such that the service package doesn't
+// depend on OpenTelemetry.
+package tracing
+
+import (
+	"github.com/optable/optable-pkglib/service"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithOpenTelemetry traces the RPCs with OpenTelemetry, see otelgrpc. The
+// spans are created by the given TracerProvider, or the global one if nil, and
+// are children of the span propagated in the incoming metadata, see
+// otelgrpc.WithPropagators. Tracing is the outermost interceptor such that the
+// handlers and the other interceptors observe the span in their context, see
+// service.WithOuterInterceptors.
+func WithOpenTelemetry(tp trace.TracerProvider, otelOpts ...otelgrpc.Option) service.ServiceOption {
+	if tp != nil {
+		otelOpts = append([]otelgrpc.Option{otelgrpc.WithTracerProvider(tp)}, otelOpts...)
+	}
+	return service.WithOuterInterceptors(otelgrpc.UnaryServerInterceptor(otelOpts...), otelgrpc.StreamServerInterceptor(otelOpts...))
+}
@@ -0,0 +1,60 @@
+// Copyright © 2021 Optable Technologies Inc. All rights reserved.
+// See LICENSE for details.
+package tracing
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/optable/optable-pkglib/service"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestWithOpenTelemetry(t *testing.T) {
+	previous := prometheus.DefaultRegisterer
+	prometheus.DefaultRegisterer = prometheus.NewRegistry()
+	t.Cleanup(func() { prometheus.DefaultRegisterer = previous })
+
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	propagator := otelgrpc.WithPropagators(propagation.TraceContext{})
+
+	server, err := service.NewGRPCService(context.Background(), health.NewServer(), service.WithDescriptors(&healthpb.Health_ServiceDesc), nil, nil,
+		WithOpenTelemetry(tp, propagator))
+	require.NoError(t, err)
+
+	l := bufconn.Listen(1024 * 1024)
+	go func() { _ = server.Serve(l) }()
+	defer server.Stop()
+
+	// The client propagates its span in the metadata.
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }
+	conn, err := grpc.Dial("bufconn", grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor(propagator)))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "frontend")
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	parent.End()
+
+	var names []string
+	for _, span := range spans.Ended() {
+		names = append(names, span.Name())
+		assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext().TraceID())
+	}
+	assert.Contains(t, names, "grpc.health.v1.Health/Check")
+}
@@ -2,6 +2,13 @@
 // See LICENSE for details.
 package unit
 
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
 const (
 	Byte = 1
 
@@ -18,3 +25,72 @@ const (
 	TiB = Tebibyte
 	PiB = Pebibyte
 )
+
+const (
+	// The decimal (SI) prefix are powers of 1000.
+	Kilobyte = Byte * 1000
+	Megabyte = Kilobyte * 1000
+	Gigabyte = Megabyte * 1000
+	Terabyte = Gigabyte * 1000
+	Petabyte = Terabyte * 1000
+
+	KB = Kilobyte
+	MB = Megabyte
+	GB = Gigabyte
+	TB = Terabyte
+	PB = Petabyte
+)
+
+// byteSuffixes maps the lowercased suffixes accepted by ParseBytes to their
+// multiplier.
+var byteSuffixes = map[string]int64{
+	"":    Byte,
+	"b":   Byte,
+	"kb":  KB,
+	"mb":  MB,
+	"gb":  GB,
+	"tb":  TB,
+	"pb":  PB,
+	"kib": KiB,
+	"mib": MiB,
+	"gib": GiB,
+	"tib": TiB,
+	"pib": PiB,
+}
+
+// ParseBytes parses a human readable size, e.g. "512", "2KB", "10MiB" or
+// "1.5 GiB", into a number of bytes. Both binary (IEC, e.g. KiB) and decimal
+// (SI, e.g. KB) suffixes are supported, case-insensitively, and a missing
+// suffix means bytes. Fractional sizes are rounded to the nearest byte.
+func ParseBytes(s string) (int64, error) {
+	trimmed := strings.TrimSpace(s)
+	split := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split == -1 {
+		split = len(trimmed)
+	}
+	number, suffix := trimmed[:split], strings.TrimSpace(trimmed[split:])
+
+	multiplier, ok := byteSuffixes[strings.ToLower(suffix)]
+	if !ok {
+		return 0, fmt.Errorf("Invalid byte size '%s': unknown unit '%s'", s, suffix)
+	}
+
+	if n, err := strconv.ParseInt(number, 10, 64); err == nil {
+		if n > math.MaxInt64/multiplier {
+			return 0, fmt.Errorf("Invalid byte size '%s': overflows int64", s)
+		}
+		return n * multiplier, nil
+	}
+
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid byte size '%s': invalid number '%s'", s, number)
+	}
+	size := math.Round(f * float64(multiplier))
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("Invalid byte size '%s': overflows int64", s)
+	}
+	return int64(size), nil
+}
@@ -23,3 +23,40 @@ func TestByteUnits(t *testing.T) {
 	assert.Equal(t, TiB, Tebibyte)
 	assert.Equal(t, PiB, Pebibyte)
 }
+
+func TestParseBytes(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"0":         0,
+		"512":       512,
+		"512B":      512,
+		" 2KB ":     2 * KB,
+		"2kb":       2 * KB,
+		"10MiB":     10 * MiB,
+		"8 mib":     8 * MiB,
+		"1.5 GiB":   GiB + GiB/2,
+		"1.1MB":     1100 * KB,
+		"0.5KiB":    512,
+		"3TB":       3 * TB,
+		"1PiB":      PiB,
+		"8191PiB":   8191 * PiB,
+		"100000 GB": 100000 * GB,
+	} {
+		n, err := ParseBytes(s)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, expected, n, s)
+		}
+	}
+
+	for _, s := range []string{"", "MiB", "10XB", "-1", "1..5KB", "1 2", "8192PiB", "1e3"} {
+		_, err := ParseBytes(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestDecimalByteUnits(t *testing.T) {
+	assert.Equal(t, 1000, Kilobyte)
+	assert.Equal(t, 1000*1000, Megabyte)
+	assert.Equal(t, 1000*1000*1000, Gigabyte)
+	assert.Equal(t, 1000*1000*1000*1000, Terabyte)
+	assert.Equal(t, 1000*1000*1000*1000*1000, Petabyte)
+}